@@ -0,0 +1,333 @@
+package chord
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// ID is the id of a node or the hash of a key.
+// It is printed as a decimal number.
+type ID []byte
+
+func (id ID) String() string {
+	if id == nil {
+		return "-"
+	}
+	return toBigInt(id).String()
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(id.String())
+}
+
+// NodeRef identifies a node in the routing state of
+// another node along with the time at which that
+// entry was last updated or confirmed
+type NodeRef struct {
+	Id      ID
+	Address string
+	Updated time.Time
+}
+
+// FingerInfo is the i'th entry of a finger table
+type FingerInfo struct {
+	Index int
+
+	// id n + 2^i which the finger points past
+	Start ID
+
+	NodeRef
+}
+
+// TransferInfo describes a transfer of keys
+// which has not yet completed
+type TransferInfo struct {
+	Id      string
+	To      string
+	Keys    int
+	Sent    int
+	Started time.Time
+}
+
+// StabilizeInfo is the outcome of the
+// latest stabilize round of a node
+type StabilizeInfo struct {
+	At        time.Time
+	Successor string
+
+	// empty if the round succeeded
+	Err string
+}
+
+// NodeInfo is a dump of the internal state of a node
+type NodeInfo struct {
+	Id      ID
+	Address string
+
+	// time on the node when the dump was taken,
+	// to be compared with Updated timestamps
+	Time time.Time
+
+	// nil if the predecessor is not known
+	Predecessor *NodeRef
+
+	Successors []NodeRef
+	Fingers    []FingerInfo
+
+	// replicas following the node in its chain
+	Chain []string
+
+	// the node owns keys whose hash lies in
+	// (RangeStart, RangeEnd]. RangeStart is nil
+	// if the predecessor is not known
+	RangeStart ID
+	RangeEnd   ID
+
+	Keys             int
+	Hints            int
+	Fragments        int
+	PendingTransfers []TransferInfo
+	LastStabilize    StabilizeInfo
+
+	// bytes held by the node and Config.Capacity,
+	// 0 if the node has no capacity limit
+	Bytes    int
+	Capacity int
+}
+
+// Returns the finger table of node
+func (node *Node) fingers() []FingerInfo {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	fingers := make([]FingerInfo, 0, len(node.fingerTable))
+	for i, finger := range node.fingerTable {
+		if finger == nil {
+			continue
+		}
+		fingers = append(fingers, FingerInfo{
+			Index:   i,
+			Start:   fingerId(node.id, i),
+			NodeRef: NodeRef{finger.id, finger.address, finger.updated},
+		})
+	}
+	return fingers
+}
+
+// Returns the successor list of node
+func (node *Node) successors() []NodeRef {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	successors := make([]NodeRef, 0, len(node.successorList))
+	for _, successor := range node.successorList {
+		successors = append(successors, NodeRef{successor.id, successor.address, successor.updated})
+	}
+	return successors
+}
+
+// Refresh the successor list by prepending our
+// successor to the successor list of our successor
+func (node *Node) updateSuccessorList() error {
+	node.mutex.RLock()
+	successor := *node.fingerTable[0]
+	size := node.config.SuccessorListSize
+	node.mutex.RUnlock()
+
+	list := []*Finger{{successor.id, successor.address, time.Now()}}
+
+	if successor.address != node.address {
+		successorRPC, err := node.getClient(successor.address)
+		if err != nil {
+			return err
+		}
+		defer successorRPC.Close()
+
+		var next []NodeRef
+		if err = successorRPC.Call("RPCNode.GetSuccessorList", "", &next); err != nil {
+			return err
+		}
+
+		// stop once the list wraps around to us
+		for _, ref := range next {
+			if len(list) >= size || ref.Address == node.address {
+				break
+			}
+			list = append(list, &Finger{ref.Id, ref.Address, time.Now()})
+		}
+	}
+
+	node.mutex.Lock()
+	node.successorList = list
+	node.mutex.Unlock()
+
+	go node.reconfigureChain(false)
+	return nil
+}
+
+// Record the outcome of a stabilize round
+func (node *Node) recordStabilize(err error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.lastStabilize = StabilizeInfo{
+		At:        time.Now(),
+		Successor: node.fingerTable[0].address,
+	}
+	if err != nil {
+		node.lastStabilize.Err = err.Error()
+	}
+}
+
+// Mark a transfer of keys to the node at
+// address "to" as pending. The returned function
+// marks it as complete.
+func (node *Node) trackTransfer(id string, to string, keys int) func() {
+	node.mutex.Lock()
+	node.transfers[id] = TransferInfo{id, to, keys, 0, time.Now()}
+	node.mutex.Unlock()
+
+	return func() {
+		node.mutex.Lock()
+		delete(node.transfers, id)
+		node.mutex.Unlock()
+	}
+}
+
+// Returns the successor list of the node
+func (node *RPCNode) GetSuccessorList(_ *string, reply *[]NodeRef) error {
+	*reply = node.successors()
+	return nil
+}
+
+// Returns the finger table of the node
+func (node *RPCNode) GetFingerTable(_ *string, reply *[]FingerInfo) error {
+	*reply = node.fingers()
+	return nil
+}
+
+// Returns a dump of the internal state of the node
+func (node *RPCNode) Inspect(_ *string, info *NodeInfo) error {
+	info.Fingers = node.fingers()
+	info.Successors = node.successors()
+
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	info.Id = node.id
+	info.Address = node.address
+	info.Time = time.Now()
+
+	if node.predecessorId != nil {
+		info.Predecessor = &NodeRef{node.predecessorId, node.predecessorAddr, node.predecessorUpdated}
+	}
+	info.RangeStart = node.predecessorId
+	info.RangeEnd = node.id
+
+	info.Chain = node.chain
+	info.Keys = node.store.live()
+	info.Hints = node.hintCount
+	info.Fragments = len(node.fragments)
+	info.Bytes = node.storedBytes()
+	info.Capacity = node.config.Capacity
+	for _, transfer := range node.transfers {
+		info.PendingTransfers = append(info.PendingTransfers, transfer)
+	}
+	info.LastStabilize = node.lastStabilize
+	return nil
+}
+
+// Returns the keys stored on the node in sorted order
+func (node *RPCNode) GetKeys(_ *string, keys *[]string) error {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	*keys = make([]string, 0, node.store.len())
+	node.store.each(func(key string, _ Item) {
+		if _, ok := node.store.get(key); ok {
+			*keys = append(*keys, key)
+		}
+	})
+	sort.Strings(*keys)
+	return nil
+}
+
+// Makes the node leave the network. The node
+// is stopped after the reply has been sent
+func (node *RPCNode) Leave(_ *string, _ *string) error {
+	go node.Stop()
+	return nil
+}
+
+// Inspect returns a dump of the internal
+// state of the node the client is connected to
+func (c *Client) Inspect() (*NodeInfo, error) {
+	info := new(NodeInfo)
+	if err := c.call("RPCNode.Inspect", "", info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Fingers returns the finger table of the
+// node the client is connected to
+func (c *Client) Fingers() ([]FingerInfo, error) {
+	var fingers []FingerInfo
+	err := c.call("RPCNode.GetFingerTable", "", &fingers)
+	return fingers, err
+}
+
+// Successors returns the successor list of
+// the node the client is connected to
+func (c *Client) Successors() ([]NodeRef, error) {
+	var successors []NodeRef
+	err := c.call("RPCNode.GetSuccessorList", "", &successors)
+	return successors, err
+}
+
+// Keys returns the keys stored on the
+// node the client is connected to
+func (c *Client) Keys() ([]string, error) {
+	var keys []string
+	err := c.call("RPCNode.GetKeys", "", &keys)
+	return keys, err
+}
+
+// Leave makes the node the client is connected to
+// leave the network. Its keys are transferred to
+// its successor.
+func (c *Client) Leave() error {
+	var reply string
+	return c.call("RPCNode.Leave", "", &reply)
+}
+
+// Ring walks the ring along successors starting from
+// the node the client is connected to and returns the
+// state of every node on the way
+func (c *Client) Ring() ([]*NodeInfo, error) {
+	info, err := c.Inspect()
+	if err != nil {
+		return nil, err
+	}
+
+	ring := []*NodeInfo{info}
+	visited := map[string]bool{info.Address: true}
+	for len(info.Successors) > 0 && !visited[info.Successors[0].Address] {
+		next, err := DialWith(info.Successors[0].Address, c.creds)
+		if err != nil {
+			return ring, err
+		}
+		info, err = next.Inspect()
+		next.Close()
+		if err != nil {
+			return ring, err
+		}
+		ring = append(ring, info)
+		visited[info.Address] = true
+	}
+	return ring, nil
+}
@@ -0,0 +1,212 @@
+package chord
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+)
+
+// NodeUnit is the organizational unit of the certificates
+// of nodes, which are trusted as members of the ring when
+// Config.CertificateAuth is set
+const NodeUnit = "chord-node"
+
+// HTTP header carrying the challenge a node sends
+// to every connection when Config.ClusterKey is set
+const challengeHeader = "Chord-Challenge"
+
+// Methods which clients may call without proving that they
+// are members of the ring. Every other method is internal
+// to the ring once authentication is enabled.
+var clientMethods = map[string]bool{
+	"RPCNode.Authenticate": true,
+	"RPCNode.Unauthorized": true,
+
+	"RPCNode.Retrieve":     true,
+	"RPCNode.RetrieveItem": true,
+	"RPCNode.Read":         true,
+	"RPCNode.MultiGet":     true,
+	"RPCNode.Write":        true,
+	"RPCNode.Save":         true,
+	"RPCNode.Delete":       true,
+	"RPCNode.MultiWrite":   true,
+
+	"RPCNode.NamespaceUsage": true,
+
+	"RPCNode.Successor":           true,
+	"RPCNode.FindSuccessor":       true,
+	"RPCNode.SecureFindSuccessor": true,
+	"RPCNode.Check":               true,
+	"RPCNode.GetId":               true,
+	"RPCNode.Identity":            true,
+	"RPCNode.GetPredecessor":      true,
+	"RPCNode.GetSuccessorList":    true,
+	"RPCNode.GetFingerTable":      true,
+	"RPCNode.GetKeys":             true,
+	"RPCNode.GetLimits":           true,
+	"RPCNode.Inspect":             true,
+}
+
+// Credentials are what a node or client presents
+// when connecting to a node
+type Credentials struct {
+	// TLS settings of the connection, nil for plain text
+	TLS *tls.Config
+
+	// key shared by the members of the ring, see
+	// Config.ClusterKey. nil connects as a client.
+	ClusterKey []byte
+}
+
+// Returns the credentials the node connects to other nodes with
+func (node *Node) credentials() Credentials {
+	return Credentials{node.config.TLS, node.config.ClusterKey}
+}
+
+// Check if connections must prove to be
+// members of the ring to call internal methods
+func (node *Node) authEnabled() bool {
+	return node.config.ClusterKey != nil || node.config.CertificateAuth
+}
+
+// Returns the proof of knowing key for a challenge sent by
+// the node at address. The address is covered so that a proof
+// cannot be relayed to another node.
+func challengeProof(key, challenge []byte, address string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(challenge)
+	mac.Write([]byte(address))
+	return mac.Sum(nil)
+}
+
+// Check if the peer of a TLS connection presented
+// a verified certificate of a node of the ring
+func memberCertificate(state *tls.ConnectionState) bool {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return false
+	}
+	for _, unit := range state.VerifiedChains[0][0].Subject.OrganizationalUnit {
+		if unit == NodeUnit {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the common name of the verified certificate
+// the peer of a TLS connection presented, if any
+func certificateName(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// Connects to the rpc server of the node at address over conn
+// with the same HTTP CONNECT as rpc.DialHTTP. If the node sends
+// a challenge and key is set, the connection is authenticated.
+func connectRPC(conn net.Conn, address string, key []byte) (*rpc.Client, error) {
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != connected {
+		return nil, ErrUnableToDial
+	}
+	client := rpc.NewClient(conn)
+
+	challenge, err := hex.DecodeString(resp.Header.Get(challengeHeader))
+	if key == nil || len(challenge) == 0 || err != nil {
+		return client, nil
+	}
+	proof := challengeProof(key, challenge, address)
+	if err = client.Call("RPCNode.Authenticate", &proof, new(string)); err != nil {
+		client.Close()
+		return nil, ErrUnauthenticated
+	}
+	return client, nil
+}
+
+// Returns a random challenge, sent hex encoded
+func newChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// Called before the rpc server looks up the method of a
+// request. Calls to internal methods over connections which
+// are not of members are turned into calls to Unauthorized.
+func (c *serverCodec) authorize(r *rpc.Request) {
+	if r.ServiceMethod == "RPCNode.Authenticate" {
+		c.authenticating = true
+		return
+	}
+	if c.member || !c.node.authEnabled() || clientMethods[r.ServiceMethod] {
+		return
+	}
+
+	c.node.logger.Warn("rejected unauthenticated call", "method", r.ServiceMethod)
+	r.ServiceMethod = "RPCNode.Unauthorized"
+	c.denied = true
+}
+
+// Checks the proof of an Authenticate call, body of which has
+// been decoded. A proof which does not match is cleared.
+func (c *serverCodec) authenticate(body interface{}) {
+	c.authenticating = false
+
+	proof, ok := body.(*[]byte)
+	if !ok {
+		return
+	}
+	if c.challenge != nil && hmac.Equal(*proof, challengeProof(c.node.config.ClusterKey, c.challenge, c.node.address)) {
+		c.member = true
+		return
+	}
+	*proof = nil
+}
+
+// Clears the fields of writes which only nodes may set, in
+// the arguments of a client method, body of which has been
+// decoded. Fragments are stored by the node coordinating a
+// write, so that clients cannot forge where they are held.
+func (c *serverCodec) clearInternal(body interface{}) {
+	if c.member {
+		return
+	}
+	switch c.method {
+	case "RPCNode.Write":
+		body.(*WriteRequest).Erasure = nil
+	case "RPCNode.MultiWrite":
+		reqs := *body.(*[]WriteRequest)
+		for i := range reqs {
+			reqs[i].Erasure = nil
+		}
+	}
+}
+
+// Makes the connection a member connection if the proof of
+// knowing Config.ClusterKey matches. The proof is checked by
+// the connection before the call, which clears it if it does
+// not match.
+func (node *RPCNode) Authenticate(proof *[]byte, _ *string) error {
+	if len(*proof) == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Serves calls to internal methods over connections
+// which have not proved to be members of the ring
+func (node *RPCNode) Unauthorized(_ *string, _ *string) error {
+	return ErrUnauthorized
+}
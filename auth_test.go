@@ -0,0 +1,116 @@
+package chord
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChallengeProofBindsAddress(t *testing.T) {
+	key := []byte("cluster key")
+	challenge, err := newChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof := challengeProof(key, challenge, "127.0.0.1:35383")
+	if !bytes.Equal(proof, challengeProof(key, challenge, "127.0.0.1:35383")) {
+		t.Fatal("proof is not deterministic")
+	}
+	if bytes.Equal(proof, challengeProof(key, challenge, "127.0.0.1:35384")) {
+		t.Error("proof can be relayed to another node")
+	}
+	if bytes.Equal(proof, challengeProof([]byte("other key"), challenge, "127.0.0.1:35383")) {
+		t.Error("proof does not depend on the key")
+	}
+	other, _ := newChallenge()
+	if bytes.Equal(proof, challengeProof(key, other, "127.0.0.1:35383")) {
+		t.Error("proof does not depend on the challenge")
+	}
+}
+
+func TestClusterKeyGuardsInternalMethods(t *testing.T) {
+	key := []byte("cluster key")
+	config := testConfig(freeAddress(t), "")
+	config.ClusterKey = key
+	node, err := CreateNewNodeWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+
+	id := "transfer"
+	var next int
+
+	// clients may call client methods only
+	client, err := Dial(node.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = client.Put("key", []byte("value")); err != nil {
+		t.Errorf("client write: %v", err)
+	}
+	if err = client.call("RPCNode.TransferStatus", &id, &next); err != ErrUnauthorized {
+		t.Errorf("internal method called by client: %v", err)
+	}
+
+	// the rejected call did not break the connection
+	if _, err = client.Get("key"); err != nil {
+		t.Errorf("read after rejected call: %v", err)
+	}
+
+	if _, err = DialWith(node.address, Credentials{ClusterKey: []byte("wrong key")}); err != ErrUnauthenticated {
+		t.Errorf("wrong cluster key accepted: %v", err)
+	}
+
+	member, err := DialWith(node.address, Credentials{ClusterKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+	if err = member.call("RPCNode.TransferStatus", &id, &next); err != nil {
+		t.Errorf("internal method called by member: %v", err)
+	}
+}
+
+func TestCertificateAuthGuardsInternalMethods(t *testing.T) {
+	r := sharedRing(t)
+
+	// the client certificate is not that of a node
+	// so internal methods are denied
+	client := r.client(t)
+	defer client.Close()
+	id := "transfer"
+	var next int
+	if err := client.call("RPCNode.TransferStatus", &id, &next); err != ErrUnauthorized {
+		t.Fatalf("internal method called by client: %v", err)
+	}
+
+	member, err := getClient(r.nodes[1].address, r.nodes[0].credentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+	if err = member.Call("RPCNode.TransferStatus", &id, &next); err != nil {
+		t.Errorf("internal method called by member: %v", err)
+	}
+}
+
+func TestClientsCannotForgeFragments(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	forged := &Erasure{Id: "forged", Size: 1, Data: 1, Holders: []string{"127.0.0.1:1"}}
+	if _, err := client.Write(&WriteRequest{Key: "forged", Value: []byte("value"), Erasure: forged}); err != nil {
+		t.Fatal(err)
+	}
+
+	owner := r.node(mustLookup(t, client, "forged"))
+	owner.mutex.RLock()
+	item, _ := owner.store.get("forged")
+	owner.mutex.RUnlock()
+	if item.Erasure != nil || string(item.Value) != "value" {
+		t.Errorf("stored %+v", item)
+	}
+}
@@ -0,0 +1,399 @@
+package chord
+
+import (
+	"bytes"
+	"net/rpc"
+	"sort"
+	"sync"
+)
+
+// KeyResult is the outcome of a batch
+// operation for a single Key
+type KeyResult struct {
+	Key string
+
+	// Value read, set only by MultiGet
+	Value []byte
+
+	// version read or written
+	Version uint64
+
+	// address of the node which owns the Key
+	Node string
+
+	// error message, empty if the operation on the
+	// Key succeeded. It can be compared with the
+	// message of errors such as ErrNoKeyValuePair
+	Err string
+}
+
+// Groups the indices of keys by the address of the
+// node owning each key. Keys are visited in order of
+// their hash so that a lookup is needed only for the
+// first key owned by each node.
+func (node *Node) groupByOwner(keys []string) map[string][]int {
+	hashes := make([][]byte, len(keys))
+	order := make([]int, len(keys))
+	for i, key := range keys {
+		hashes[i] = getHash(key)
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(hashes[order[a]], hashes[order[b]]) == -1
+	})
+
+	groups := make(map[string][]int)
+
+	// keys whose hash lies in (start, owner.Id] have
+	// the same owner as the key whose hash is start
+	var start []byte
+	var owner Lookup
+	for _, i := range order {
+		hash := hashes[i]
+		sameOwner := start != nil && (equal(hash, start) ||
+			(!equal(start, owner.Id) && betweenRightInc(hash, start, owner.Id)))
+		if !sameOwner {
+			start = hash
+			owner = node.resolve(hash)
+		}
+		groups[owner.Address] = append(groups[owner.Address], i)
+	}
+	return groups
+}
+
+// Groups keys by their owners and calls batch for every
+// owner in parallel with the indices of the keys it owns, in
+// batches of about Config.TransferChunkSize bytes as counted
+// by size, so that each fits within the message limit of the
+// owner. batch fills results for those indices, results of
+// keys whose batch failed hold the error.
+func (node *Node) batchByOwner(keys []string, results []KeyResult, size func(i int) int, batch func(owner *rpc.Client, indices []int) error) {
+	var wg sync.WaitGroup
+	for owner, indices := range node.groupByOwner(keys) {
+		wg.Add(1)
+		go func(owner string, indices []int) {
+			defer wg.Done()
+
+			ownerRPC, dialErr := node.getClient(owner)
+			if dialErr == nil {
+				defer ownerRPC.Close()
+			}
+
+			for len(indices) > 0 {
+				end, total := 0, 0
+				for end < len(indices) && (end == 0 || total+size(indices[end]) <= node.config.TransferChunkSize) {
+					total += size(indices[end])
+					end++
+				}
+				sent := indices[:end]
+				indices = indices[end:]
+
+				err := dialErr
+				if err == nil {
+					err = batch(ownerRPC, sent)
+				}
+				for _, i := range sent {
+					results[i].Key = keys[i]
+					results[i].Node = owner
+					if err != nil {
+						results[i].Err = err.Error()
+					}
+				}
+			}
+		}(owner, indices)
+	}
+	wg.Wait()
+}
+
+// Applies writes at the nodes owning their Keys
+// sending them in batches to each owner
+func (node *Node) multiWrite(reqs []WriteRequest) []KeyResult {
+	keys := make([]string, len(reqs))
+	for i := range reqs {
+		keys[i] = reqs[i].Key
+	}
+
+	// large Values are stored as fragments first and
+	// their Erasure is written in the batch instead
+	results := make([]KeyResult, len(reqs))
+	failed := make(map[int]bool)
+	for i := range reqs {
+		if err := node.checkSize(&reqs[i]); err != nil {
+			results[i] = KeyResult{Key: keys[i], Err: err.Error()}
+			failed[i] = true
+			continue
+		}
+		if !node.erasureCoded(&reqs[i]) {
+			continue
+		}
+		erasure, _, err := node.storeFragments(node.resolve(getHash(keys[i])), &reqs[i])
+		if err != nil {
+			results[i] = KeyResult{Key: keys[i], Err: err.Error()}
+			failed[i] = true
+			continue
+		}
+		reqs[i].Value, reqs[i].Erasure = nil, erasure
+	}
+
+	size := func(i int) int {
+		if failed[i] {
+			return 0
+		}
+		return reqs[i].encodedSize()
+	}
+	node.batchByOwner(keys, results, size, func(owner *rpc.Client, indices []int) error {
+		batch := make([]WriteRequest, 0, len(indices))
+		sent := make([]int, 0, len(indices))
+		for _, i := range indices {
+			if !failed[i] {
+				batch = append(batch, reqs[i])
+				sent = append(sent, i)
+			}
+		}
+		if len(sent) == 0 {
+			return nil
+		}
+
+		var reply []KeyResult
+		if err := owner.Call("RPCNode.ApplyBatch", &batch, &reply); err != nil {
+			return err
+		}
+		if len(reply) != len(sent) {
+			return ErrInvalidBatchReply
+		}
+		for j, i := range sent {
+			results[i] = reply[j]
+		}
+		return nil
+	})
+	return results
+}
+
+// Reads Keys from the nodes owning them
+// sending them in batches to each owner
+func (node *Node) multiGet(keys []string) []KeyResult {
+	results := make([]KeyResult, len(keys))
+	size := func(i int) int {
+		return encodingOverhead + len(keys[i])
+	}
+	node.batchByOwner(keys, results, size, func(owner *rpc.Client, indices []int) error {
+		batch := make([]string, len(indices))
+		for j, i := range indices {
+			batch[j] = keys[i]
+		}
+
+		var reply []KeyResult
+		if err := owner.Call("RPCNode.GetBatch", &batch, &reply); err != nil {
+			return err
+		}
+		if len(reply) != len(indices) {
+			return ErrInvalidBatchReply
+		}
+		for j, i := range indices {
+			results[i] = reply[j]
+		}
+		return nil
+	})
+	return results
+}
+
+// Applies a batch of writes to node's store
+func (node *RPCNode) ApplyBatch(reqs *[]WriteRequest, results *[]KeyResult) error {
+	node.mutex.Lock()
+	*results = make([]KeyResult, len(*reqs))
+	written := make([]string, 0, len(*reqs))
+	for i := range *reqs {
+		req := &(*reqs)[i]
+		result := &(*results)[i]
+
+		result.Key = req.Key
+		version, err := node.applyWrite(req)
+		result.Version = version
+		if err != nil {
+			result.Err = err.Error()
+			continue
+		}
+		written = append(written, req.Key)
+	}
+	items := node.itemsOf(written...)
+	node.mutex.Unlock()
+
+	node.replicate(items, ConsistencyOne)
+	return nil
+}
+
+// Reads a batch of Keys from node's store
+func (node *RPCNode) GetBatch(keys *[]string, results *[]KeyResult) error {
+	node.mutex.RLock()
+	*results = make([]KeyResult, len(*keys))
+	coded := make(map[int]Item)
+	for i, key := range *keys {
+		result := &(*results)[i]
+
+		result.Key = key
+		item, ok := node.store.get(key)
+		if !ok {
+			result.Err = ErrNoKeyValuePair.Error()
+			continue
+		}
+		if item.Erasure != nil {
+			coded[i] = item
+		}
+		result.Value = item.Value
+		result.Version = item.Version
+	}
+	node.mutex.RUnlock()
+
+	// fragments are read once the store is unlocked
+	for i, item := range coded {
+		result := &(*results)[i]
+		if err := node.rebuild(result.Key, &item); err != nil {
+			result.Err = err.Error()
+			continue
+		}
+		result.Value = item.Value
+	}
+	return nil
+}
+
+// Applies writes to many Keys in chord network. Writes
+// are grouped by owner and the owners are written to in
+// parallel. Results are in the same order as the writes.
+func (node *RPCNode) MultiWrite(reqs *[]WriteRequest, results *[]KeyResult) error {
+	*results = node.multiWrite(*reqs)
+	return nil
+}
+
+// Reads many Keys from chord network. Results
+// are in the same order as the keys.
+func (node *RPCNode) MultiGet(keys *[]string, results *[]KeyResult) error {
+	*results = node.multiGet(*keys)
+	return nil
+}
+
+// Calls send with the ranges [start, end) of 0 to n-1 in
+// order, in batches of about clientBatchSize bytes as counted
+// by size, so that each fits within the message limit of any
+// node. Stops at the first error.
+func clientBatches(n int, size func(i int) int, send func(start, end int) error) error {
+	for start := 0; start < n; {
+		end, total := start, 0
+		for end < n && (end == start || total+size(end) <= clientBatchSize) {
+			total += size(end)
+			end++
+		}
+		if err := send(start, end); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// MultiWrite applies the writes and returns the result
+// of each write in the same order. Many writes are sent
+// in several batches, those before a batch which fails
+// are applied.
+func (c *Client) MultiWrite(reqs []WriteRequest) ([]KeyResult, error) {
+	qualified := make([]WriteRequest, len(reqs))
+	results := make([]KeyResult, len(reqs))
+
+	// writes over the limits of the node fail
+	// alone, without being sent
+	sent := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		var err error
+		qualified[i] = req
+		qualified[i].Key = c.key(req.Key)
+		if qualified[i].Value, err = c.seal(req.Key, req.Value); err != nil {
+			return nil, err
+		}
+		if err = c.limits.check(&qualified[i]); err != nil {
+			results[i] = KeyResult{Key: req.Key, Err: err.Error()}
+			continue
+		}
+		sent = append(sent, i)
+	}
+
+	size := func(j int) int {
+		return qualified[sent[j]].encodedSize()
+	}
+	err := clientBatches(len(sent), size, func(start, end int) error {
+		batch := make([]WriteRequest, 0, end-start)
+		for _, i := range sent[start:end] {
+			batch = append(batch, qualified[i])
+		}
+		var reply []KeyResult
+		if err := c.call("RPCNode.MultiWrite", &batch, &reply); err != nil {
+			return err
+		}
+		if len(reply) != len(batch) {
+			return ErrInvalidBatchReply
+		}
+		for j, i := range sent[start:end] {
+			results[i] = reply[j]
+			results[i].Key = reqs[i].Key
+		}
+		return nil
+	})
+	return results, err
+}
+
+// MultiPut saves the Key-Value pairs
+func (c *Client) MultiPut(pairs []KeyValue) ([]KeyResult, error) {
+	reqs := make([]WriteRequest, len(pairs))
+	for i, pair := range pairs {
+		reqs[i] = WriteRequest{Key: pair.Key, Value: pair.Value}
+	}
+	return c.MultiWrite(reqs)
+}
+
+// MultiDelete deletes the Keys
+func (c *Client) MultiDelete(keys []string) ([]KeyResult, error) {
+	reqs := make([]WriteRequest, len(keys))
+	for i, key := range keys {
+		reqs[i] = WriteRequest{Key: key, Delete: true}
+	}
+	return c.MultiWrite(reqs)
+}
+
+// MultiGet reads the Keys and returns the
+// result of each read in the same order
+func (c *Client) MultiGet(keys []string) ([]KeyResult, error) {
+	qualified := make([]string, len(keys))
+	for i, key := range keys {
+		qualified[i] = c.key(key)
+	}
+
+	results := make([]KeyResult, 0, len(keys))
+	size := func(i int) int {
+		return encodingOverhead + len(qualified[i])
+	}
+	err := clientBatches(len(qualified), size, func(start, end int) error {
+		batch := qualified[start:end]
+		var reply []KeyResult
+		if err := c.call("RPCNode.MultiGet", &batch, &reply); err != nil {
+			return err
+		}
+		if len(reply) != len(batch) {
+			return ErrInvalidBatchReply
+		}
+		results = append(results, reply...)
+		return nil
+	})
+	if err != nil {
+		return results, err
+	}
+	c.unqualify(results, keys)
+
+	// a Value which cannot be decrypted fails its read alone
+	for i := range results {
+		value, err := c.open(results[i].Key, results[i].Value)
+		if err != nil {
+			results[i].Value, results[i].Err = nil, err.Error()
+			continue
+		}
+		results[i].Value = value
+	}
+	return results, nil
+}
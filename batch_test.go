@@ -0,0 +1,71 @@
+package chord
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGroupByOwner(t *testing.T) {
+	r := sharedRing(t)
+	node := r.nodes[0]
+
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprint("group-", i)
+	}
+	groups := node.groupByOwner(keys)
+
+	seen := make(map[int]bool)
+	for owner, indices := range groups {
+		for _, i := range indices {
+			if seen[i] {
+				t.Errorf("%s grouped twice", keys[i])
+			}
+			seen[i] = true
+			if lookup := node.resolve(getHash(keys[i])); lookup.Address != owner {
+				t.Errorf("%s grouped with %s, owned by %s", keys[i], owner, lookup.Address)
+			}
+		}
+	}
+	if len(seen) != len(keys) {
+		t.Errorf("grouped %d of %d keys", len(seen), len(keys))
+	}
+}
+
+func TestMultiWriteAndGet(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	pairs := make([]KeyValue, 30)
+	keys := make([]string, len(pairs))
+	for i := range pairs {
+		keys[i] = fmt.Sprint("multi-", i)
+		pairs[i] = KeyValue{keys[i], []byte(keys[i])}
+	}
+	results, err := client.MultiPut(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Err != "" {
+			t.Errorf("put %s: %s", keys[i], result.Err)
+		}
+	}
+
+	results, err = client.MultiGet(append(keys, "multi-missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(keys)+1 {
+		t.Fatalf("got %d results for %d keys", len(results), len(keys)+1)
+	}
+	for i, key := range keys {
+		if string(results[i].Value) != key {
+			t.Errorf("get %s = %q", key, results[i].Value)
+		}
+	}
+	if results[len(keys)].Err == "" {
+		t.Error("missing key read")
+	}
+}
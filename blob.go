@@ -0,0 +1,79 @@
+package chord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix of the Keys blobs are stored under,
+// followed by the hex encoded hash of the blob
+const blobPrefix = "sha256:"
+
+// BlobKey returns the Key the blob with given
+// hash, as returned by PutBlob, is stored under
+func BlobKey(hash string) string {
+	return blobPrefix + hash
+}
+
+// Returns the hex encoded sha256 hash of data
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the hash a Key names if it is the Key of a blob,
+// within a namespace or not, false if it is not
+func blobOf(key string) (string, bool) {
+	_, key = splitNamespace(key)
+	if !strings.HasPrefix(key, blobPrefix) {
+		return "", false
+	}
+	return key[len(blobPrefix):], true
+}
+
+// Checks a write to the Key of a blob at its owner. The Value
+// must hash to the Key. Blobs are immutable, so writes to a
+// blob which exists are applied as no-ops, returning true.
+// The node's lock must be held.
+func (node *Node) checkBlob(req *WriteRequest) (uint64, bool, error) {
+	hash, ok := blobOf(req.Key)
+	if !ok || req.Delete {
+		return 0, false, nil
+	}
+	if req.Erasure != nil || blobHash(req.Value) != hash {
+		node.logger.Warn("rejected blob not matching its hash", "key", req.Key)
+		return 0, false, ErrBlobMismatch
+	}
+	if current, ok := node.store.get(req.Key); ok {
+		return current.Version, true, nil
+	}
+	return 0, false, nil
+}
+
+// PutBlob saves data under the hash of data itself, which it
+// returns. The owner checks that data matches the hash, and
+// blobs cannot be changed once written. Blobs are stored in
+// the namespace of the client but are not encrypted.
+func (c *Client) PutBlob(data []byte) (string, error) {
+	hash := blobHash(data)
+	req := &WriteRequest{Key: NamespacedKey(c.namespace, BlobKey(hash)), Value: data}
+	if err := c.limits.check(req); err != nil {
+		return hash, err
+	}
+	return hash, c.call("RPCNode.Write", req, new(WriteResult))
+}
+
+// GetBlob returns the blob with given hash. Blobs which do
+// not match their hash fail with ErrBlobMismatch.
+func (c *Client) GetBlob(hash string) ([]byte, error) {
+	var data []byte
+	key := NamespacedKey(c.namespace, BlobKey(hash))
+	if err := c.call("RPCNode.Retrieve", &key, &data); err != nil {
+		return nil, err
+	}
+	if blobHash(data) != hash {
+		return nil, ErrBlobMismatch
+	}
+	return data, nil
+}
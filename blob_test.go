@@ -0,0 +1,63 @@
+package chord
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBlobs(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	// the blob is corrupted below, so every run
+	// of the test writes a blob of its own
+	data := []byte(fmt.Sprint("contents of a blob ", time.Now().UnixNano()))
+	hash, err := client.PutBlob(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != blobHash(data) {
+		t.Errorf("hash = %s", hash)
+	}
+	read, err := client.GetBlob(hash)
+	if err != nil || string(read) != string(data) {
+		t.Fatalf("read %q, %v", read, err)
+	}
+
+	// writing it again changes nothing
+	before, _ := client.GetItem(BlobKey(hash))
+	if _, err = client.PutBlob(data); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := client.GetItem(BlobKey(hash)); after.Version != before.Version {
+		t.Errorf("version %d after writing the blob again, was %d", after.Version, before.Version)
+	}
+
+	// the owner rejects data which does not match the hash
+	_, err = client.Write(&WriteRequest{Key: BlobKey(hash), Value: []byte("other contents")})
+	if err != ErrBlobMismatch {
+		t.Errorf("write of data not matching the hash: %v", err)
+	}
+
+	// and readers detect data corrupted on the owner
+	owner := r.node(mustLookup(t, client, BlobKey(hash)))
+	owner.mutex.Lock()
+	item, _ := owner.store.stored(BlobKey(hash))
+	item.Value = []byte("corrupted contents")
+	owner.store.put(BlobKey(hash), item)
+	owner.mutex.Unlock()
+	if _, err = client.GetBlob(hash); err != ErrBlobMismatch {
+		t.Errorf("read of corrupted blob: %v", err)
+	}
+}
+
+func TestBlobOf(t *testing.T) {
+	if hash, ok := blobOf(NamespacedKey("team", BlobKey("abc"))); !ok || hash != "abc" {
+		t.Errorf("blob in namespace = %q, %v", hash, ok)
+	}
+	if _, ok := blobOf("abc"); ok {
+		t.Error("plain key taken for a blob")
+	}
+}
@@ -0,0 +1,201 @@
+package chord
+
+// ChainWrite is a write travelling down
+// the chain of the node owning its Keys
+type ChainWrite struct {
+	// members of the chain still to
+	// receive the write, in order
+	Chain []string
+
+	Items []TransferItem
+}
+
+// Returns this node followed by the members of its
+// chain, the replicas which hold every write the node
+// acknowledged at ConsistencyLinearizable. The last
+// member is the tail.
+func (node *Node) chainMembers() []string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return append([]string{node.address}, node.chain...)
+}
+
+// Applies a write at the head of the chain and passes it
+// down the chain. Returns once the tail holds the write, or
+// ErrNotEnoughReplicas if a member of the chain could not be
+// reached. The write is then applied down to that member
+// only, and is not read until the chain is rebuilt.
+func (node *Node) chainWrite(req *WriteRequest, result *WriteResult) error {
+	// reconfiguration waits for writes
+	// in flight down the chain
+	node.chainMutex.RLock()
+	defer node.chainMutex.RUnlock()
+
+	node.mutex.Lock()
+	version, err := node.applyWrite(req)
+	items := node.itemsOf(req.Key)
+	chain := append([]string(nil), node.chain...)
+	node.mutex.Unlock()
+
+	result.Version = version
+	if err != nil {
+		return err
+	}
+
+	held, err := node.forwardChain(chain, items)
+	result.Replicas = 1 + held
+	if err != nil {
+		node.logger.Warn("chain write not acknowledged by tail", "key", req.Key, "err", err)
+		return ErrNotEnoughReplicas
+	}
+	return nil
+}
+
+// Sends items to the first member of chain, which passes them
+// on to the rest. Members are never skipped, so that every member
+// holds the writes the tail holds. Returns the number of members
+// holding the items, an error if the tail was not reached.
+func (node *Node) forwardChain(chain []string, items []TransferItem) (int, error) {
+	if len(chain) == 0 {
+		return 0, nil
+	}
+
+	nextRPC, err := node.getClient(chain[0])
+	if err != nil {
+		return 0, err
+	}
+	defer nextRPC.Close()
+
+	var held int
+	err = nextRPC.Call("RPCNode.ChainWrite", &ChainWrite{chain[1:], items}, &held)
+	return held, err
+}
+
+// Brings the chain in line with the successor list. Replicas
+// which join the chain are sent the keys owned by this node
+// before they take part in it, so that the tail holds every
+// acknowledged write. With all set every key on the node is
+// sent to every replica, as when the node takes over the keys
+// of a failed predecessor.
+func (node *Node) reconfigureChain(all bool) {
+	targets := node.replicaTargets()
+
+	node.mutex.RLock()
+	unchanged := len(targets) == len(node.chain)
+	for i := 0; unchanged && i < len(targets); i++ {
+		unchanged = targets[i] == node.chain[i]
+	}
+	node.mutex.RUnlock()
+	if unchanged && !all {
+		return
+	}
+
+	node.chainMutex.Lock()
+	defer node.chainMutex.Unlock()
+
+	node.mutex.RLock()
+	members := make(map[string]bool)
+	for _, member := range node.chain {
+		members[member] = !all
+	}
+	start := node.predecessorId
+	items := make([]TransferItem, 0)
+	node.store.each(func(key string, item Item) {
+		if all || start == nil || betweenRightInc(getHash(key), start, node.id) {
+			items = append(items, TransferItem{key, item})
+		}
+	})
+	node.mutex.RUnlock()
+
+	chain := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if !members[target] {
+			if err := node.sendItems(target, items); err != nil {
+				node.logger.Warn("unable to add replica to chain", "replica", target, "err", err)
+				continue
+			}
+		}
+		chain = append(chain, target)
+	}
+
+	node.mutex.Lock()
+	node.chain = chain
+	node.mutex.Unlock()
+	node.logger.Info("reconfigured chain", "chain", chain, "keys", len(items))
+}
+
+// Sends items to replica in batches
+func (node *Node) sendItems(replica string, items []TransferItem) error {
+	replicaRPC, err := node.getClient(replica)
+	if err != nil {
+		return err
+	}
+	defer replicaRPC.Close()
+	return node.replicateTo(replicaRPC, items)
+}
+
+// Reads a Key from the tail of the chain of its owner. Only
+// the tail is read, the members before it may hold writes
+// which are not acknowledged yet.
+func (node *Node) readTail(req *ReadRequest, result *ReadResult) error {
+	owner, err := node.getClient(result.Node)
+	if err != nil {
+		return err
+	}
+
+	var chain []string
+	err = owner.Call("RPCNode.Chain", "", &chain)
+	owner.Close()
+	if err != nil {
+		return err
+	}
+	result.Total = len(chain)
+	if len(chain) == 0 {
+		return ErrNotEnoughReplicas
+	}
+
+	tailRPC, err := node.getClient(chain[len(chain)-1])
+	if err != nil {
+		return ErrNotEnoughReplicas
+	}
+	var item Item
+	err = tailRPC.Call("RPCNode.ReadReplica", &req.Key, &item)
+	tailRPC.Close()
+	if err != nil && err.Error() != ErrNoKeyValuePair.Error() {
+		return ErrNotEnoughReplicas
+	}
+
+	result.Replicas = 1
+	if err != nil || item.Deleted {
+		return ErrNoKeyValuePair
+	}
+	result.Item = item
+	if item.Erasure != nil {
+		return node.rebuild(req.Key, &result.Item)
+	}
+	return nil
+}
+
+// Saves a write passed down the chain and passes it on to
+// the rest of the chain. Replies with the number of members,
+// this node included, which hold the write, or an error if
+// the tail could not be reached.
+func (node *RPCNode) ChainWrite(req *ChainWrite, held *int) error {
+	node.mutex.Lock()
+	for _, ti := range req.Items {
+		node.store.merge(ti.Key, ti.Item)
+	}
+	node.mutex.Unlock()
+
+	rest, err := node.forwardChain(req.Chain, req.Items)
+	*held = 1 + rest
+	return err
+}
+
+// Replies with the node followed by the members of its
+// chain. The last member is the tail, which serves reads
+// at ConsistencyLinearizable.
+func (node *RPCNode) Chain(_ *string, chain *[]string) error {
+	*chain = node.chainMembers()
+	return nil
+}
@@ -27,7 +27,7 @@ def node_data(request, key):
     c.execute("""SELECT * FROM chord;""")
     dbnodes = c.fetchone()
     data = {
-        'value': subprocess.check_output(f"go run ../example/ret.go {dbnodes[0]} {key}", shell=True).decode("utf-8")
+        'value': subprocess.check_output(f"go run ../cmd/chordctl get -node {dbnodes[0]} {key}", shell=True).decode("utf-8")
     }
     return JsonResponse(data)
 def node_pdata(request, key, value):
@@ -37,6 +37,6 @@ def node_pdata(request, key, value):
     c.execute("""SELECT * FROM chord;""")
     dbnodes = c.fetchone()
     data = {
-        'value': subprocess.check_output(f"go run ../example/save.go {dbnodes[0]} {key} {value}", shell=True).decode("utf-8")
+        'value': subprocess.check_output(f"go run ../cmd/chordctl put -node {dbnodes[0]} {key} {value}", shell=True).decode("utf-8")
     }
     return JsonResponse(data)
\ No newline at end of file
@@ -0,0 +1,213 @@
+package chord
+
+import (
+	"crypto/tls"
+	"net/rpc"
+	"time"
+)
+
+// Client talks to a chord network through
+// one of the nodes of the network.
+type Client struct {
+	// address of the node the client is
+	// connected to
+	address string
+
+	// rpc client of that node
+	rpc *rpc.Client
+
+	// credentials the client connected with, used
+	// again when it connects to other nodes
+	creds Credentials
+
+	// limits of the node on Keys and Values, which
+	// writes are checked against before being sent
+	limits Limits
+
+	// namespace of the keys the client
+	// reads and writes, see Namespace
+	namespace string
+
+	// encrypts Values, nil if they are
+	// not encrypted, see WithEncryption
+	sealer *sealer
+}
+
+// Dial connects to the node at address
+func Dial(address string) (*Client, error) {
+	return DialTLS(address, nil)
+}
+
+// DialTLS connects to the node at address over
+// TLS as per config, or in plain text if nil
+func DialTLS(address string, config *tls.Config) (*Client, error) {
+	return DialWith(address, Credentials{TLS: config})
+}
+
+// DialWith connects to the node at address with creds.
+// Clients with the cluster key may call the methods
+// internal to the ring, such as Leave.
+func DialWith(address string, creds Credentials) (*Client, error) {
+	client, err := getClient(address, creds)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{address: address, rpc: client, creds: creds}
+
+	// writes are left to the node to check
+	// if its limits cannot be read
+	c.call("RPCNode.GetLimits", "", &c.limits)
+	return c, nil
+}
+
+// DialSeeds connects to the first node in
+// seeds which can be reached
+func DialSeeds(seeds []string) (*Client, error) {
+	return DialSeedsTLS(seeds, nil)
+}
+
+// Same as DialSeeds but connects over
+// TLS as per config
+func DialSeedsTLS(seeds []string, config *tls.Config) (*Client, error) {
+	return DialSeedsWith(seeds, Credentials{TLS: config})
+}
+
+// Same as DialSeeds but connects with creds
+func DialSeedsWith(seeds []string, creds Credentials) (*Client, error) {
+	for _, seed := range seeds {
+		if client, err := DialWith(seed, creds); err == nil {
+			return client, nil
+		}
+	}
+	return nil, ErrUnableToDial
+}
+
+// Address returns the address of the node
+// the client is connected to
+func (c *Client) Address() string {
+	return c.address
+}
+
+// Close the connection to the node
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	return remoteError(c.rpc.Call(method, args, reply))
+}
+
+// Get returns the Value associated with the Key
+func (c *Client) Get(key string) ([]byte, error) {
+	var value []byte
+	name := c.key(key)
+	if err := c.call("RPCNode.Retrieve", &name, &value); err != nil {
+		return nil, err
+	}
+	return c.open(key, value)
+}
+
+// Put saves the Key-Value pair and returns the
+// address of the node where it was stored
+func (c *Client) Put(key string, value []byte) (string, error) {
+	var storeNode string
+	value, err := c.seal(key, value)
+	if err != nil {
+		return "", err
+	}
+	kv := KeyValue{c.key(key), value}
+	if err = c.limits.check(&WriteRequest{Key: kv.Key, Value: kv.Value}); err != nil {
+		return "", err
+	}
+	err = c.call("RPCNode.Save", kv, &storeNode)
+	return storeNode, err
+}
+
+// PutWithTTL saves the Key-Value pair which expires
+// after ttl and returns its version
+func (c *Client) PutWithTTL(key string, value []byte, ttl time.Duration) (uint64, error) {
+	result, err := c.Write(&WriteRequest{Key: key, Value: value, TTL: ttl})
+	return result.Version, err
+}
+
+// Write applies the write at the node owning the Key
+func (c *Client) Write(req *WriteRequest) (*WriteResult, error) {
+	result := new(WriteResult)
+	qualified := *req
+	qualified.Key = c.key(req.Key)
+	var err error
+	if qualified.Value, err = c.seal(req.Key, req.Value); err != nil {
+		return result, err
+	}
+	if err = c.limits.check(&qualified); err != nil {
+		return result, err
+	}
+	err = c.call("RPCNode.Write", &qualified, result)
+	return result, err
+}
+
+// Delete removes the Key-Value pair and returns the
+// address of the node where it was stored
+func (c *Client) Delete(key string) (string, error) {
+	var storeNode string
+	key = c.key(key)
+	err := c.call("RPCNode.Delete", &key, &storeNode)
+	return storeNode, err
+}
+
+// Lookup finds the node responsible for the Key. Path
+// of the returned lookup holds the nodes through which
+// the lookup was routed.
+func (c *Client) Lookup(key string) (*Lookup, error) {
+	lookup := new(Lookup)
+	if err := c.call("RPCNode.FindSuccessor", getHash(c.key(key)), lookup); err != nil {
+		return nil, err
+	}
+	return lookup, nil
+}
+
+// GetItem returns the Value associated with
+// the Key along with its version
+func (c *Client) GetItem(key string) (*Item, error) {
+	item := new(Item)
+	name := c.key(key)
+	if err := c.call("RPCNode.RetrieveItem", &name, item); err != nil {
+		return nil, err
+	}
+	if err := c.openItem(key, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CompareAndSwap sets the Value of the Key only if its
+// current version is expectedVersion, an expectedVersion
+// of 0 means the Key must not exist. Returns the new
+// version, or ErrVersionMismatch if the version differed.
+func (c *Client) CompareAndSwap(key string, expectedVersion uint64, value []byte) (uint64, error) {
+	result, err := c.Write(&WriteRequest{
+		Key:         key,
+		Value:       value,
+		Conditional: true,
+		Version:     expectedVersion,
+	})
+	return result.Version, err
+}
+
+// PutIfAbsent saves the Key-Value pair only
+// if the Key does not exist yet
+func (c *Client) PutIfAbsent(key string, value []byte) (uint64, error) {
+	return c.CompareAndSwap(key, 0, value)
+}
+
+// DeleteIfVersion deletes the Key only if
+// its current version is version
+func (c *Client) DeleteIfVersion(key string, version uint64) error {
+	_, err := c.Write(&WriteRequest{
+		Key:         key,
+		Delete:      true,
+		Conditional: true,
+		Version:     version,
+	})
+	return err
+}
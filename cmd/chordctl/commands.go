@@ -0,0 +1,537 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	chord "github.com/kateposp/dht-chord"
+)
+
+// Print v as JSON or, for table output,
+// as the rows written by table
+func (ctx *context) print(v interface{}, table func(w *tabwriter.Writer)) {
+	if ctx.config.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	w.Flush()
+}
+
+func startNode(ctx *context) error {
+	if ctx.args[0] != "start" {
+		return fmt.Errorf("unknown command node %s", ctx.args[0])
+	}
+	if ctx.addr == "" {
+		return fmt.Errorf("node start needs -addr")
+	}
+
+	levels := map[string]chord.Level{
+		"debug": chord.LevelDebug,
+		"info":  chord.LevelInfo,
+		"warn":  chord.LevelWarn,
+		"error": chord.LevelError,
+	}
+	level, ok := levels[strings.ToLower(ctx.logLevel)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", ctx.logLevel)
+	}
+
+	config := chord.DefaultConfig(ctx.addr, ctx.join)
+	config.Logger = chord.NewLogger(os.Stderr, level)
+	config.ReplicationFactor = ctx.replicas
+	config.VectorClocks = ctx.vectorClocks
+	config.ErasureThreshold = ctx.erasureThreshold
+	config.SecureLookups = ctx.secureLookups
+	config.ClientRateLimit = chord.RateLimit{Rate: ctx.rateLimit, Burst: ctx.rateBurst}
+	config.MaxInFlight = ctx.maxInFlight
+	config.MaxKeySize = ctx.maxKeySize
+	config.MaxValueSize = ctx.maxValueSize
+	config.Capacity = ctx.capacity
+	config.TLS = ctx.tls
+	config.ClusterKey = ctx.clusterKey
+	config.CertificateAuth = ctx.certAuth
+	if ctx.namespacesFile != "" {
+		namespaces, err := loadNamespaces(ctx.namespacesFile)
+		if err != nil {
+			return err
+		}
+		config.Namespaces = namespaces
+	}
+	if ctx.identityFile != "" {
+		identity, err := chord.LoadIdentity(ctx.identityFile)
+		if err != nil {
+			return err
+		}
+		config.Identity = identity
+	}
+	if ctx.masterKeyFile != "" && ctx.dataFile == "" {
+		return fmt.Errorf("-master-key-file needs -data")
+	}
+	if ctx.dataFile != "" {
+		engine, err := chord.NewSQLiteEngine(ctx.dataFile)
+		if err != nil {
+			return err
+		}
+		config.Engine = engine
+		config.MasterKeyFile = ctx.masterKeyFile
+	}
+	node, err := chord.CreateNewNodeWithConfig(config)
+	if err != nil {
+		if config.Engine != nil {
+			config.Engine.Close()
+		}
+		return err
+	}
+
+	// run until interrupted or until the
+	// node is asked to leave
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	select {
+	case <-interrupt:
+		node.Stop()
+	case <-node.Stopped():
+	}
+	return nil
+}
+
+func get(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	key := ctx.args[0]
+	read, err := client.GetWithConsistency(key, ctx.consistency)
+	if err != nil {
+		return err
+	}
+	item := read.Item
+
+	result := map[string]interface{}{
+		"key":      key,
+		"value":    string(item.Value),
+		"version":  item.Version,
+		"replicas": read.Replicas,
+		"total":    read.Total,
+	}
+	if !item.Expires.IsZero() {
+		result["expires"] = item.Expires
+	}
+
+	// concurrent values are listed
+	// along with the context
+	var values []string
+	for _, sibling := range item.Siblings {
+		if !sibling.Deleted {
+			values = append(values, string(sibling.Value))
+		}
+	}
+	if len(item.Siblings) > 0 {
+		result["context"] = item.Context().String()
+	}
+	if len(values) > 1 {
+		result["siblings"] = values
+	}
+
+	ctx.print(result, func(w *tabwriter.Writer) {
+		if len(values) > 1 {
+			for _, value := range values {
+				fmt.Fprintln(w, value)
+			}
+			return
+		}
+		fmt.Fprintln(w, string(item.Value))
+	})
+	return nil
+}
+
+func put(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	req := &chord.WriteRequest{
+		Key:         ctx.args[0],
+		Value:       []byte(ctx.args[1]),
+		Conditional: ctx.ifVersion >= 0,
+		TTL:         ctx.ttl,
+		Consistency: ctx.consistency,
+		Context:     ctx.context,
+	}
+	if req.Conditional {
+		req.Version = uint64(ctx.ifVersion)
+	}
+
+	result, err := client.Write(req)
+	if err != nil {
+		return err
+	}
+
+	output := map[string]interface{}{"key": req.Key, "node": result.Node, "version": result.Version, "replicas": result.Replicas}
+	if result.Fragments > 0 {
+		output["fragments"] = result.Fragments
+	}
+	ctx.print(output, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%s\tversion %d\t%d replicas", result.Node, result.Version, result.Replicas)
+		if result.Fragments > 0 {
+			fmt.Fprintf(w, "\t%d fragments", result.Fragments)
+		}
+		fmt.Fprintln(w)
+	})
+	return nil
+}
+
+func del(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	key := ctx.args[0]
+	if ctx.ifVersion >= 0 {
+		return client.DeleteIfVersion(key, uint64(ctx.ifVersion))
+	}
+	if len(ctx.context) > 0 {
+		return client.DeleteWithContext(key, ctx.context)
+	}
+
+	storeNode, err := client.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	ctx.print(map[string]string{"key": key, "node": storeNode}, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, storeNode)
+	})
+	return nil
+}
+
+func lookup(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ctx.secure {
+		return secureLookup(ctx, client)
+	}
+
+	result, err := client.Lookup(ctx.args[0])
+	if err != nil {
+		return err
+	}
+	if !ctx.trace {
+		result.Path = nil
+	}
+
+	ctx.print(result, func(w *tabwriter.Writer) {
+		if !ctx.trace {
+			fmt.Fprintln(w, result.Address)
+			return
+		}
+		fmt.Fprintln(w, "hop\taddress")
+		for i, hop := range result.Path {
+			fmt.Fprintf(w, "%d\t%s\n", i, hop)
+		}
+		fmt.Fprintf(w, "owner\t%s\n", result.Address)
+	})
+	return nil
+}
+
+// Prints the owner of a key found by redundant lookups,
+// along with every lookup when tracing
+func secureLookup(ctx *context, client *chord.Client) error {
+	result, err := client.SecureLookup(ctx.args[0])
+	if err != nil {
+		return err
+	}
+	if !ctx.trace {
+		result.Path = nil
+		result.Lookups = nil
+	}
+
+	ctx.print(result, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "owner\t%s\n", result.Address)
+		fmt.Fprintf(w, "agreed\t%t\n", result.Agreed)
+		if len(result.Suspicious) > 0 {
+			fmt.Fprintf(w, "suspicious\t%s\n", strings.Join(result.Suspicious, ", "))
+		}
+		for i, lookup := range result.Lookups {
+			fmt.Fprintf(w, "lookup %d\t%s\t%s\n", i, lookup.Address, strings.Join(lookup.Path, " -> "))
+		}
+	})
+	return nil
+}
+
+func ring(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	nodes, err := client.Ring()
+	if err != nil {
+		return err
+	}
+
+	ctx.print(nodes, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "address\tid\tpredecessor\tsuccessor\tkeys")
+		for _, info := range nodes {
+			predecessor, successor := "-", "-"
+			if info.Predecessor != nil {
+				predecessor = info.Predecessor.Address
+			}
+			if len(info.Successors) > 0 {
+				successor = info.Successors[0].Address
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", info.Address, info.Id, predecessor, successor, info.Keys)
+		}
+	})
+	return nil
+}
+
+func inspect(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	info, err := client.Inspect()
+	if err != nil {
+		return err
+	}
+
+	ctx.print(info, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "node\t%s\n", info.Address)
+		fmt.Fprintf(w, "id\t%s\n", info.Id)
+		fmt.Fprintf(w, "range\t(%s, %s]\n", info.RangeStart, info.RangeEnd)
+		fmt.Fprintf(w, "keys\t%d\n", info.Keys)
+		fmt.Fprintf(w, "hints\t%d\n", info.Hints)
+		fmt.Fprintf(w, "fragments\t%d\n", info.Fragments)
+		if info.Capacity > 0 {
+			fmt.Fprintf(w, "bytes\t%d of %d\n", info.Bytes, info.Capacity)
+		} else {
+			fmt.Fprintf(w, "bytes\t%d\n", info.Bytes)
+		}
+		if len(info.Chain) > 0 {
+			fmt.Fprintf(w, "chain\t%s\n", strings.Join(info.Chain, " -> "))
+		}
+		if info.Predecessor != nil {
+			fmt.Fprintf(w, "predecessor\t%s\t%s\n", info.Predecessor.Address, age(info.Time, info.Predecessor.Updated))
+		} else {
+			fmt.Fprintf(w, "predecessor\t-\n")
+		}
+
+		stabilize := info.LastStabilize
+		result := "ok"
+		if stabilize.Err != "" {
+			result = stabilize.Err
+		}
+		fmt.Fprintf(w, "last stabilize\t%s\t%s\tsuccessor %s\n", age(info.Time, stabilize.At), result, stabilize.Successor)
+
+		for _, transfer := range info.PendingTransfers {
+			fmt.Fprintf(w, "transfer\t%s\t%d/%d keys\tstarted %s\n", transfer.To, transfer.Sent, transfer.Keys, age(info.Time, transfer.Started))
+		}
+
+		fmt.Fprintln(w, "\nsuccessors")
+		printRefs(w, info.Time, info.Successors)
+		fmt.Fprintln(w, "\nfingers")
+		printFingers(w, info.Time, info.Fingers)
+	})
+	return nil
+}
+
+func fingers(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	fingers, err := client.Fingers()
+	if err != nil {
+		return err
+	}
+
+	ctx.print(fingers, func(w *tabwriter.Writer) {
+		printFingers(w, time.Now(), fingers)
+	})
+	return nil
+}
+
+func successors(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	successors, err := client.Successors()
+	if err != nil {
+		return err
+	}
+
+	ctx.print(successors, func(w *tabwriter.Writer) {
+		printRefs(w, time.Now(), successors)
+	})
+	return nil
+}
+
+func keys(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	keys, err := client.Keys()
+	if err != nil {
+		return err
+	}
+
+	ctx.print(keys, func(w *tabwriter.Writer) {
+		for _, key := range keys {
+			fmt.Fprintln(w, key)
+		}
+	})
+	return nil
+}
+
+func nsUsage(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	usage, err := client.NamespaceUsage(ctx.args[0])
+	if err != nil {
+		return err
+	}
+
+	ctx.print(usage, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "node\t%s\n", client.Address())
+		fmt.Fprintf(w, "keys\t%d\n", usage.Keys)
+		fmt.Fprintf(w, "bytes\t%d\n", usage.Bytes)
+	})
+	return nil
+}
+
+func rotate(ctx *context) error {
+	if ctx.config.Encryption == nil {
+		return fmt.Errorf("no encryption keys in the config file")
+	}
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Rotate(ctx.args[0]); err != nil {
+		return err
+	}
+	ctx.print(map[string]string{"key": ctx.args[0]}, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%s is encrypted with key %d\n", ctx.args[0], ctx.config.Encryption.Current)
+	})
+	return nil
+}
+
+func putBlob(ctx *context) error {
+	var data []byte
+	var err error
+	if ctx.args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(ctx.args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	hash, err := client.PutBlob(data)
+	if err != nil {
+		return err
+	}
+	ctx.print(map[string]interface{}{"hash": hash, "size": len(data)}, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, hash)
+	})
+	return nil
+}
+
+func getBlob(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	data, err := client.GetBlob(ctx.args[0])
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func leave(ctx *context) error {
+	client, err := ctx.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Leave(); err != nil {
+		return err
+	}
+
+	ctx.print(map[string]string{"node": client.Address()}, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%s is leaving\n", client.Address())
+	})
+	return nil
+}
+
+func printRefs(w *tabwriter.Writer, now time.Time, refs []chord.NodeRef) {
+	fmt.Fprintln(w, "#\taddress\tid\tupdated")
+	for i, ref := range refs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i, ref.Address, ref.Id, age(now, ref.Updated))
+	}
+}
+
+func printFingers(w *tabwriter.Writer, now time.Time, fingers []chord.FingerInfo) {
+	fmt.Fprintln(w, "#\tstart\taddress\tid\tupdated")
+	for _, finger := range fingers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", finger.Index, finger.Start, finger.Address, finger.Id, age(now, finger.Updated))
+	}
+}
+
+// Format time elapsed between then and now
+func age(now, then time.Time) string {
+	if then.IsZero() {
+		return "never"
+	}
+	return now.Sub(then).Round(time.Millisecond).String() + " ago"
+}
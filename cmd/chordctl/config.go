@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	chord "github.com/kateposp/dht-chord"
+)
+
+// config is read from a JSON file such as
+//
+//	{
+//		"seeds": ["10.0.0.1:9988", "10.0.0.2:9988"],
+//		"output": "table",
+//		"tls": {"cert": "client.pem", "key": "client-key.pem", "ca": "ca.pem"},
+//		"cluster_key_file": "cluster.key",
+//		"namespace": "billing",
+//		"encryption": {"name_key": "name.key", "keys": {"1": "value-1.key", "2": "value-2.key"}, "current": 2}
+//	}
+type config struct {
+	// nodes tried in order when connecting
+	// to the network
+	Seeds []string `json:"seeds"`
+
+	// default output format, table or json
+	Output string `json:"output"`
+
+	// certificate files used to connect over TLS
+	TLS tlsFiles `json:"tls"`
+
+	// file holding the key shared by the nodes of
+	// the ring, see chord.Config.ClusterKey
+	ClusterKeyFile string `json:"cluster_key_file"`
+
+	// namespace of the keys read and written,
+	// empty for keys outside any namespace
+	Namespace string `json:"namespace"`
+
+	// keys values are encrypted with, nil if
+	// they are not, see chord.Encryption
+	Encryption *encryptionFiles `json:"encryption"`
+}
+
+// encryptionFiles are the files of the keys of
+// chord.Encryption, each holding a hex encoded key
+type encryptionFiles struct {
+	NameKey string            `json:"name_key"`
+	Keys    map[uint32]string `json:"keys"`
+	Current uint32            `json:"current"`
+}
+
+// Reads the keys of files
+func (files *encryptionFiles) load() (chord.Encryption, error) {
+	enc := chord.Encryption{Keys: make(map[uint32][]byte), Current: files.Current}
+	var err error
+	if enc.NameKey, err = readKey(files.NameKey); err != nil {
+		return enc, err
+	}
+	for id, path := range files.Keys {
+		if enc.Keys[id], err = readKey(path); err != nil {
+			return enc, err
+		}
+	}
+	return enc, nil
+}
+
+// Reads a hex encoded key from path
+func readKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+// tlsFiles are the PEM files of a certificate,
+// its key and the CA which peers are checked against
+type tlsFiles struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+}
+
+// Load the namespaces of a started node from a JSON file
+// mapping each namespace to its chord.Namespace, such as
+//
+//	{
+//		"billing": {"Readers": ["*"], "Writers": ["billing-svc"], "Admins": ["ops"], "MaxKeys": 10000}
+//	}
+func loadNamespaces(path string) (map[string]chord.Namespace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces map[string]chord.Namespace
+	if err = json.Unmarshal(data, &namespaces); err != nil {
+		return nil, err
+	}
+	return namespaces, nil
+}
+
+// Returns $CHORDCTL_CONFIG if set else
+// chordctl/config.json in user's config dir
+func defaultConfigPath() string {
+	if path := os.Getenv("CHORDCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chordctl", "config.json")
+}
+
+// Load config from path. A missing
+// file results in an empty config
+func loadConfig(path string) (*config, error) {
+	conf := &config{Output: "table"}
+	if path == "" {
+		return conf, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
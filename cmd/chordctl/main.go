@@ -0,0 +1,331 @@
+// chordctl runs and operates the nodes of a chord network.
+//
+// Nodes to connect to are taken from the -seeds flag, or
+// from the seed list in the config file (see config.go).
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	chord "github.com/kateposp/dht-chord"
+)
+
+const usage = `usage: chordctl <command> [flags] [args]
+
+commands:
+  node start -addr <addr> [-join <addr>] [-replicas <n>] [-vector-clocks] [-erasure-threshold <bytes>]
+             [-identity-file <file>] [-secure-lookups <n>]
+             [-rate-limit <per second>] [-rate-burst <n>] [-max-in-flight <n>]
+             [-namespaces-file <file>] [-max-key-size <bytes>] [-max-value-size <bytes>]
+             [-capacity <bytes>] [-data <file> [-master-key-file <file>]]
+                                          run a node until interrupted
+  get [-consistency <level>] <key>        print the value, or concurrent values, of a key
+  put [-if-version <n>] [-ttl <duration>] [-consistency <level>] [-context <clock>] <key> <value>
+                                          save a key-value pair, version 0 only if absent
+  delete [-if-version <n>] [-context <clock>] <key>
+                                          delete a key-value pair
+  lookup [-trace] [-secure] <key>         find the node storing a key, -secure by redundant lookups
+  ring                                    list the nodes of the ring
+  inspect [-node <addr>]                  dump the internal state of a node
+  fingers [-node <addr>]                  print the finger table of a node
+  successors [-node <addr>]               print the successor list of a node
+  keys [-node <addr>]                     list the keys stored on a node
+  leave [-node <addr>]                    make a node leave the ring
+  usage [-node <addr>] <namespace>        print what a namespace stores on a node
+  rotate <key>                            encrypt a value again with the current key of the config file
+  put-blob <file>                         save the contents of a file, - for stdin, and print their hash
+  get-blob <hash>                         write a blob to stdout after checking it matches its hash
+
+common flags:
+  -config <file>     config file (default $CHORDCTL_CONFIG or ` + "`~/.config/chordctl/config.json`" + `)
+  -seeds <a,b,...>   nodes to connect to, overrides the config file
+  -o table|json      output format
+  -tls-cert <file> -tls-key <file> -tls-ca <file>
+                     connect over TLS, and serve TLS for node start
+  -mtls              node start requires clients and nodes to present a certificate
+  -cluster-key-file <file>
+                     key shared by the nodes of the ring, needed by leave once set
+  -cert-auth         node start trusts peers with node certificates as members
+  -namespace <name>  namespace of the keys read and written, overrides the config file
+
+consistency levels are one (default), quorum, all and linearizable. -context takes the
+context printed by get -o json to replace the values read. Commands rejected by an
+overloaded node exit with status 75 and may be retried after backing off.
+`
+
+// command is one chordctl verb
+type command struct {
+	// number of positional arguments
+	args int
+
+	run func(ctx *context) error
+}
+
+var commands = map[string]command{
+	"node":       {1, startNode},
+	"get":        {1, get},
+	"put":        {2, put},
+	"delete":     {1, del},
+	"lookup":     {1, lookup},
+	"ring":       {0, ring},
+	"inspect":    {0, inspect},
+	"fingers":    {0, fingers},
+	"successors": {0, successors},
+	"keys":       {0, keys},
+	"leave":      {0, leave},
+	"usage":      {1, nsUsage},
+	"rotate":     {1, rotate},
+	"put-blob":   {1, putBlob},
+	"get-blob":   {1, getBlob},
+}
+
+// context holds the parsed flags and
+// arguments of a command
+type context struct {
+	config *config
+	args   []string
+
+	// flags specific to some commands
+	node     string
+	trace    bool
+	secure   bool
+	addr     string
+	join     string
+	logLevel string
+
+	// expected version for conditional
+	// writes, negative if unconditional
+	ifVersion int64
+
+	// lifetime of written value, 0 if
+	// it never expires
+	ttl time.Duration
+
+	// number of copies kept of each key
+	// by a started node
+	replicas int
+
+	// consistency level of reads and writes
+	consistency chord.Consistency
+
+	// versions with vector clocks on a started node
+	vectorClocks bool
+
+	// clock of the values a write replaces
+	context chord.VectorClock
+
+	// size above which a started node erasure
+	// codes values, 0 if it never does
+	erasureThreshold int
+
+	// key file of the identity of a started node,
+	// created if missing. Empty for no identity.
+	identityFile string
+
+	// number of redundant lookups a started
+	// node runs, 0 for a single lookup
+	secureLookups int
+
+	// limits of a started node on the requests
+	// of each client, 0 for no limit
+	rateLimit   float64
+	rateBurst   int
+	maxInFlight int
+
+	// JSON file of the access control and quotas
+	// of namespaces on a started node
+	namespacesFile string
+
+	// SQLite file a started node persists its keys to, and
+	// the file of master keys they are encrypted with,
+	// empty to keep keys in memory or store them plain
+	dataFile      string
+	masterKeyFile string
+
+	// size limits of a started node, 0 for the
+	// defaults or, for capacity, no limit
+	maxKeySize   int
+	maxValueSize int
+	capacity     int
+
+	// TLS settings of the connections to nodes and of
+	// a started node, nil for plain text
+	tls *tls.Config
+
+	// key shared by the nodes of the ring, nil if not set
+	clusterKey []byte
+
+	// a started node trusts peers presenting
+	// node certificates as members of the ring
+	certAuth bool
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx := new(context)
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	configPath := flags.String("config", defaultConfigPath(), "")
+	seeds := flags.String("seeds", "", "")
+	format := flags.String("o", "", "")
+	flags.StringVar(&ctx.node, "node", "", "")
+	flags.BoolVar(&ctx.trace, "trace", false, "")
+	flags.BoolVar(&ctx.secure, "secure", false, "")
+	flags.StringVar(&ctx.addr, "addr", "", "")
+	flags.StringVar(&ctx.join, "join", "", "")
+	flags.StringVar(&ctx.logLevel, "log-level", "info", "")
+	flags.Int64Var(&ctx.ifVersion, "if-version", -1, "")
+	flags.DurationVar(&ctx.ttl, "ttl", 0, "")
+	flags.IntVar(&ctx.replicas, "replicas", 1, "")
+	consistency := flags.String("consistency", "one", "")
+	flags.BoolVar(&ctx.vectorClocks, "vector-clocks", false, "")
+	context := flags.String("context", "", "")
+	flags.IntVar(&ctx.erasureThreshold, "erasure-threshold", 0, "")
+	flags.IntVar(&ctx.secureLookups, "secure-lookups", 0, "")
+	flags.Float64Var(&ctx.rateLimit, "rate-limit", 0, "")
+	flags.IntVar(&ctx.rateBurst, "rate-burst", 10, "")
+	flags.IntVar(&ctx.maxInFlight, "max-in-flight", 0, "")
+	flags.StringVar(&ctx.namespacesFile, "namespaces-file", "", "")
+	flags.StringVar(&ctx.dataFile, "data", "", "")
+	flags.StringVar(&ctx.masterKeyFile, "master-key-file", "", "")
+	flags.IntVar(&ctx.maxKeySize, "max-key-size", 0, "")
+	flags.IntVar(&ctx.maxValueSize, "max-value-size", 0, "")
+	flags.IntVar(&ctx.capacity, "capacity", 0, "")
+	namespace := flags.String("namespace", "", "")
+	flags.StringVar(&ctx.identityFile, "identity-file", "", "")
+	tlsCert := flags.String("tls-cert", "", "")
+	tlsKey := flags.String("tls-key", "", "")
+	tlsCA := flags.String("tls-ca", "", "")
+	mutual := flags.Bool("mtls", false, "")
+	clusterKeyFile := flags.String("cluster-key-file", "", "")
+	flags.BoolVar(&ctx.certAuth, "cert-auth", false, "")
+
+	// flags may appear before or after
+	// the positional arguments
+	rest := os.Args[2:]
+	for {
+		flags.Parse(rest)
+		if flags.NArg() == 0 {
+			break
+		}
+		ctx.args = append(ctx.args, flags.Arg(0))
+		rest = flags.Args()[1:]
+	}
+	if len(ctx.args) != cmd.args {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	if ctx.consistency, err = chord.ParseConsistency(*consistency); err != nil {
+		fail(err)
+	}
+	if ctx.context, err = chord.ParseVectorClock(*context); err != nil {
+		fail(err)
+	}
+
+	ctx.config, err = loadConfig(*configPath)
+	if err != nil {
+		fail(err)
+	}
+	if *seeds != "" {
+		ctx.config.Seeds = strings.Split(*seeds, ",")
+	}
+	if *format != "" {
+		ctx.config.Output = *format
+	}
+	if *namespace != "" {
+		ctx.config.Namespace = *namespace
+	}
+	if ctx.config.Output != "table" && ctx.config.Output != "json" {
+		fail(fmt.Errorf("unknown output format %q", ctx.config.Output))
+	}
+	if *tlsCert != "" || *tlsKey != "" || *tlsCA != "" {
+		ctx.config.TLS = tlsFiles{*tlsCert, *tlsKey, *tlsCA}
+	}
+	if ctx.config.TLS != (tlsFiles{}) || *mutual {
+		files := ctx.config.TLS
+		ctx.tls, err = chord.LoadTLSConfig(files.Cert, files.Key, files.CA, *mutual)
+		if err != nil {
+			fail(err)
+		}
+	}
+	if *clusterKeyFile != "" {
+		ctx.config.ClusterKeyFile = *clusterKeyFile
+	}
+	if ctx.config.ClusterKeyFile != "" {
+		key, err := os.ReadFile(ctx.config.ClusterKeyFile)
+		if err != nil {
+			fail(err)
+		}
+		ctx.clusterKey = bytes.TrimSpace(key)
+	}
+
+	if err = cmd.run(ctx); err != nil {
+		fail(err)
+	}
+}
+
+// Connect to the node given by -node flag or
+// else to the first reachable seed
+func (ctx *context) dial() (*chord.Client, error) {
+	creds := chord.Credentials{TLS: ctx.tls, ClusterKey: ctx.clusterKey}
+	var client *chord.Client
+	var err error
+	if ctx.node != "" {
+		client, err = chord.DialWith(ctx.node, creds)
+	} else if len(ctx.config.Seeds) == 0 {
+		return nil, fmt.Errorf("no seed nodes, use -seeds, -node or a config file")
+	} else {
+		client, err = chord.DialSeedsWith(ctx.config.Seeds, creds)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ctx.config.Namespace != "" {
+		client = client.Namespace(ctx.config.Namespace)
+	}
+	if files := ctx.config.Encryption; files != nil {
+		enc, err := files.load()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		encrypted, err := client.WithEncryption(enc)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		client = encrypted
+	}
+	return client, nil
+}
+
+// Exit status of commands rejected by an overloaded
+// node, which may be retried after backing off
+const exitOverloaded = 75
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "chordctl:", err)
+	if chord.IsOverloaded(err) {
+		os.Exit(exitOverloaded)
+	}
+	os.Exit(1)
+}
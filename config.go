@@ -0,0 +1,255 @@
+package chord
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"os"
+	"time"
+)
+
+// Config holds the settings with which
+// a node is created
+type Config struct {
+	// address on which the node listens
+	// i.e. for example 10.0.0.1:9988
+	Address string
+
+	// address of any node in the network to be
+	// joined. Empty address creates a new network
+	JoinAddress string
+
+	// Logger receives the log messages of node,
+	// if nil messages are written to stderr at
+	// info level
+	Logger Logger
+
+	// Log values in plain text instead of
+	// redacting them
+	LogValues bool
+
+	// Number of nodes following a node which
+	// it keeps track of in its successor list
+	SuccessorListSize int
+
+	// Interval at which expired keys are
+	// removed from the node's store
+	ExpiryInterval time.Duration
+
+	// Maximum size in bytes of a chunk of keys
+	// sent when keys are transferred between nodes
+	TransferChunkSize int
+
+	// Maximum number of chunks of a transfer
+	// awaiting acknowledgement at a time
+	TransferWindow int
+
+	// Number of nodes holding a copy of each key, the
+	// owner and the nodes following it. Copies beyond
+	// SuccessorListSize+1 are not kept. 1 disables
+	// replication.
+	ReplicationFactor int
+
+	// Interval at which a node compares the keys
+	// it owns with its replicas
+	AntiEntropyInterval time.Duration
+
+	// Time for which deleted keys are remembered
+	// so that replicas learn of the delete
+	TombstoneTTL time.Duration
+
+	// How replicas found stale by reads are
+	// repaired, in the background by default
+	ReadRepair ReadRepairMode
+
+	// Maximum number of writes a node holds as hints
+	// for unreachable nodes, and the time after which
+	// a hint which could not be replayed is dropped
+	MaxHints int
+	HintTTL  time.Duration
+
+	// Interval at which hints are replayed
+	// to the nodes they are held for
+	HintReplayInterval time.Duration
+
+	// Version Values with vector clocks instead of
+	// counters. Concurrent writes to a Key are kept
+	// as siblings until a write resolves them.
+	VectorClocks bool
+
+	// Values larger than ErasureThreshold bytes are split
+	// into DataFragments data and ParityFragments parity
+	// fragments stored on the owner and the nodes following
+	// it, instead of being replicated whole. 0 disables
+	// erasure coding.
+	ErasureThreshold int
+	DataFragments    int
+	ParityFragments  int
+
+	// TLS secures the traffic of the node, both its
+	// listener and its connections to other nodes. nil
+	// leaves the traffic in plain text. See LoadTLSConfig.
+	TLS *tls.Config
+
+	// ClusterKey is shared by the nodes of the ring, which
+	// prove knowing it to each other with HMAC. With
+	// CertificateAuth set, peers presenting a certificate
+	// of NodeUnit are members as well. When either is set
+	// only members may call the methods internal to the
+	// ring, such as those changing its topology.
+	ClusterKey      []byte
+	CertificateAuth bool
+
+	// Identity is the key of the node. When set, the id of
+	// the node is the sha1 hash of its public key rather than
+	// of its address, and nodes sign their announcements as
+	// neighbours. Peers whose announcements do not verify are
+	// not accepted as predecessors, successors or fingers. Every
+	// node of the ring must have an identity.
+	Identity ed25519.PrivateKey
+
+	// Number of independent lookups, started from different
+	// fingers, run to find the owner of a Key. Their answers
+	// are checked against each other and against the successor
+	// list. 0 or 1 runs a single lookup.
+	SecureLookups int
+
+	// Token bucket limiting the requests of each client, by
+	// its certificate or IP address, to each method clients
+	// may call. MethodRateLimits overrides it for individual
+	// methods, i.e. "RPCNode.Save". Members of the ring are
+	// not limited. Without ClusterKey or CertificateAuth,
+	// nodes cannot be told apart from clients and are
+	// limited as well.
+	ClientRateLimit  RateLimit
+	MethodRateLimits map[string]RateLimit
+
+	// Token bucket shared by the methods keeping the ring in
+	// shape, such as FindSuccessor, when clients call them.
+	// Defaults to ten times ClientRateLimit.
+	MaintenanceRateLimit RateLimit
+
+	// Maximum number of limited client requests served at a
+	// time, 0 for no limit. Requests over a limit are rejected
+	// with ErrOverloaded.
+	MaxInFlight int
+
+	// Access control and quotas of namespaces, keyed by
+	// namespace. Clients are granted access by the common
+	// name of their certificate, members of the ring have
+	// access to every namespace. Namespaces missing from
+	// the map are open to every client. Requires ClusterKey
+	// or CertificateAuth. See NamespacedKey.
+	Namespaces map[string]Namespace
+
+	// Engine the node persists its Items to and reads them
+	// back from when it starts, nil to keep them in memory
+	// only. The node closes it once it has stopped, or
+	// the caller if CreateNewNodeWithConfig fails.
+	Engine Engine
+
+	// File holding the master keys records are encrypted
+	// with before they reach Engine, see readMasterKeys.
+	// The file is read again whenever it changes, and
+	// records are then rewrapped with the current key
+	// while the node keeps serving. Empty to store
+	// records unencrypted.
+	MasterKeyFile string
+
+	// Maximum sizes in bytes of a Key and of a Value, checked
+	// both where a write enters the ring and at the owner.
+	// Messages larger than both together, or than
+	// TransferChunkSize, are skipped without being decoded
+	// and fail their call with ErrMessageTooLarge.
+	MaxKeySize   int
+	MaxValueSize int
+
+	// Bytes of Keys, Values and fragments a node holds beyond
+	// which it rejects writes with ErrCapacityExceeded. Copies
+	// held as replicas count, but are never rejected. 0 for no
+	// limit.
+	Capacity int
+}
+
+// DefaultConfig returns the config used by CreateNewNode
+func DefaultConfig(address string, joinAddress string) *Config {
+	return &Config{
+		Address:     address,
+		JoinAddress: joinAddress,
+		Logger:      NewLogger(os.Stderr, LevelInfo),
+
+		SuccessorListSize: 3,
+		ExpiryInterval:    10 * time.Second,
+		TransferChunkSize: 1 << 20,
+		TransferWindow:    4,
+
+		ReplicationFactor:   1,
+		AntiEntropyInterval: 30 * time.Second,
+		TombstoneTTL:        time.Hour,
+
+		MaxHints:           10000,
+		HintTTL:            3 * time.Hour,
+		HintReplayInterval: 10 * time.Second,
+
+		DataFragments:   4,
+		ParityFragments: 2,
+
+		MaxKeySize:   4 << 10,
+		MaxValueSize: 16 << 20,
+	}
+}
+
+// Returns a copy of config in which unset
+// fields are replaced by their defaults
+func (config Config) withDefaults() Config {
+	defaults := DefaultConfig(config.Address, config.JoinAddress)
+
+	if config.Logger == nil {
+		config.Logger = defaults.Logger
+	}
+	if config.SuccessorListSize <= 0 {
+		config.SuccessorListSize = defaults.SuccessorListSize
+	}
+	if config.ExpiryInterval <= 0 {
+		config.ExpiryInterval = defaults.ExpiryInterval
+	}
+	if config.TransferChunkSize <= 0 {
+		config.TransferChunkSize = defaults.TransferChunkSize
+	}
+	if config.TransferWindow <= 0 {
+		config.TransferWindow = defaults.TransferWindow
+	}
+	if config.ReplicationFactor <= 0 {
+		config.ReplicationFactor = defaults.ReplicationFactor
+	}
+	if config.AntiEntropyInterval <= 0 {
+		config.AntiEntropyInterval = defaults.AntiEntropyInterval
+	}
+	if config.TombstoneTTL <= 0 {
+		config.TombstoneTTL = defaults.TombstoneTTL
+	}
+	if config.MaxHints <= 0 {
+		config.MaxHints = defaults.MaxHints
+	}
+	if config.HintTTL <= 0 {
+		config.HintTTL = defaults.HintTTL
+	}
+	if config.HintReplayInterval <= 0 {
+		config.HintReplayInterval = defaults.HintReplayInterval
+	}
+	if config.DataFragments <= 0 {
+		config.DataFragments = defaults.DataFragments
+	}
+	if config.ParityFragments <= 0 {
+		config.ParityFragments = defaults.ParityFragments
+	}
+	if config.MaxKeySize <= 0 {
+		config.MaxKeySize = defaults.MaxKeySize
+	}
+	if config.MaxValueSize <= 0 {
+		config.MaxValueSize = defaults.MaxValueSize
+	}
+	if config.MaintenanceRateLimit.Rate <= 0 {
+		config.MaintenanceRateLimit = RateLimit{10 * config.ClientRateLimit.Rate, 10 * config.ClientRateLimit.Burst}
+	}
+	return config
+}
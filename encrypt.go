@@ -0,0 +1,203 @@
+package chord
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// Version of the format of encrypted Values, which are
+// the version, the id of the key, the nonce and the
+// sealed Value in that order
+const encryptionVersion = 1
+
+// Encryption holds the keys a client encrypts Values with
+// before they leave it, see Client.WithEncryption
+type Encryption struct {
+	// secret the names of keys are hashed with by HMAC, so
+	// that nodes do not see them while lookups of a key
+	// still find it. It must not change once keys are
+	// written, else they can no longer be found.
+	NameKey []byte
+
+	// AES keys of 16, 24 or 32 bytes by id. Values are
+	// encrypted with Keys[Current] and decrypted with the
+	// key they were encrypted with, so that keys can be
+	// rotated by adding a new one and making it Current.
+	Keys    map[uint32][]byte
+	Current uint32
+}
+
+// sealer encrypts and decrypts the Values of a client
+type sealer struct {
+	nameKey []byte
+	aeads   map[uint32]cipher.AEAD
+	current uint32
+}
+
+// Returns a sealer with the keys of enc
+func newSealer(enc Encryption) (*sealer, error) {
+	if len(enc.NameKey) == 0 {
+		return nil, ErrInvalidEncryptionKey
+	}
+	s := &sealer{enc.NameKey, make(map[uint32]cipher.AEAD), enc.Current}
+	for id, key := range enc.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		if s.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := s.aeads[enc.Current]; !ok {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return s, nil
+}
+
+// Returns the name key is stored under
+func (s *sealer) name(key string) string {
+	mac := hmac.New(sha256.New, s.nameKey)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypts value with the current key. The Key it is stored
+// under is authenticated so that a Value cannot be moved to
+// another Key.
+func (s *sealer) seal(key string, value []byte) ([]byte, error) {
+	aead := s.aeads[s.current]
+	sealed := make([]byte, 5+aead.NonceSize(), 5+aead.NonceSize()+len(value)+aead.Overhead())
+	sealed[0] = encryptionVersion
+	binary.BigEndian.PutUint32(sealed[1:5], s.current)
+	nonce := sealed[5:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, value, []byte(key)), nil
+}
+
+// Returns the id of the key sealed was encrypted with
+func keyId(sealed []byte) (uint32, error) {
+	if len(sealed) < 5 || sealed[0] != encryptionVersion {
+		return 0, ErrDecryptionFailed
+	}
+	return binary.BigEndian.Uint32(sealed[1:5]), nil
+}
+
+// Decrypts the Value of key sealed by seal
+func (s *sealer) open(key string, sealed []byte) ([]byte, error) {
+	id, err := keyId(sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := s.aeads[id]
+	if !ok {
+		return nil, ErrUnknownEncryptionKey
+	}
+	if len(sealed) < 5+aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	nonce := sealed[5 : 5+aead.NonceSize()]
+	value, err := aead.Open(nil, nonce, sealed[5+aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return value, nil
+}
+
+// WithEncryption returns a client which encrypts Values with
+// AES-GCM before writing them and decrypts them after reading
+// them. Names of keys are hashed with HMAC. It shares the
+// connection of c.
+func (c *Client) WithEncryption(enc Encryption) (*Client, error) {
+	s, err := newSealer(enc)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := *c
+	encrypted.sealer = s
+	return &encrypted, nil
+}
+
+// Encrypts value of key, a Key as passed to the client
+func (c *Client) seal(key string, value []byte) ([]byte, error) {
+	if c.sealer == nil || value == nil {
+		return value, nil
+	}
+	return c.sealer.seal(c.key(key), value)
+}
+
+// Decrypts value of key, a Key as passed to the client
+func (c *Client) open(key string, value []byte) ([]byte, error) {
+	if c.sealer == nil || value == nil {
+		return value, nil
+	}
+	return c.sealer.open(c.key(key), value)
+}
+
+// Decrypts the Value and the siblings of item in place
+func (c *Client) openItem(key string, item *Item) error {
+	if c.sealer == nil {
+		return nil
+	}
+	var err error
+	if item.Value, err = c.open(key, item.Value); err != nil {
+		return err
+	}
+	for i := range item.Siblings {
+		if item.Siblings[i].Value, err = c.open(key, item.Siblings[i].Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rotate encrypts the Value of the Key again with the current
+// key if it was encrypted with an older one, so that the older
+// key can be dropped once every Value has been rotated. The
+// Value is replaced only if it did not change in between, and
+// expires when it would have.
+func (c *Client) Rotate(key string) error {
+	if c.sealer == nil {
+		return nil
+	}
+	var item Item
+	name := c.key(key)
+	if err := c.call("RPCNode.RetrieveItem", &name, &item); err != nil {
+		return err
+	}
+	if len(item.Siblings) > 0 {
+		return ErrSiblingsPresent
+	}
+	if id, err := keyId(item.Value); err != nil || id == c.sealer.current {
+		return err
+	}
+
+	// the Value is written again with what is left of its TTL
+	var ttl time.Duration
+	if !item.Expires.IsZero() {
+		if ttl = time.Until(item.Expires); ttl <= 0 {
+			return nil
+		}
+	}
+
+	value, err := c.sealer.open(name, item.Value)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(&WriteRequest{
+		Key:         key,
+		Value:       value,
+		Conditional: true,
+		Version:     item.Version,
+		TTL:         ttl,
+	})
+	return err
+}
@@ -0,0 +1,150 @@
+package chord
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey  = bytes.Repeat([]byte{1}, 32)
+	newKey  = bytes.Repeat([]byte{2}, 32)
+	nameKey = []byte("name key")
+)
+
+func TestSealerOpensWhatItSeals(t *testing.T) {
+	s, err := newSealer(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey}, Current: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.seal("key", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("value")) {
+		t.Error("value sealed in plain text")
+	}
+	if id, err := keyId(sealed); err != nil || id != 1 {
+		t.Errorf("key id = %d, %v", id, err)
+	}
+
+	value, err := s.open("key", sealed)
+	if err != nil || string(value) != "value" {
+		t.Fatalf("opened %q, %v", value, err)
+	}
+	if _, err = s.open("other key", sealed); err != ErrDecryptionFailed {
+		t.Errorf("value moved to another key opened: %v", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err = s.open("key", sealed); err != ErrDecryptionFailed {
+		t.Errorf("tampered value opened: %v", err)
+	}
+}
+
+func TestSealerKeys(t *testing.T) {
+	if _, err := newSealer(Encryption{Keys: map[uint32][]byte{1: oldKey}, Current: 1}); err != ErrInvalidEncryptionKey {
+		t.Errorf("sealer without name key: %v", err)
+	}
+	if _, err := newSealer(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey}, Current: 2}); err != ErrInvalidEncryptionKey {
+		t.Errorf("sealer without its current key: %v", err)
+	}
+	if _, err := newSealer(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: []byte("short")}, Current: 1}); err != ErrInvalidEncryptionKey {
+		t.Errorf("sealer with a short key: %v", err)
+	}
+
+	old, _ := newSealer(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey}, Current: 1})
+	current, _ := newSealer(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{2: newKey}, Current: 2})
+	sealed, _ := old.seal("key", []byte("value"))
+	if _, err := current.open("key", sealed); err != ErrUnknownEncryptionKey {
+		t.Errorf("value of a dropped key opened: %v", err)
+	}
+	if old.name("key") != current.name("key") {
+		t.Error("names depend on the encryption keys")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	old, err := client.WithEncryption(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey}, Current: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = old.Put("card", []byte("4111")); err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes see neither the name nor the value
+	owner := r.node(mustLookup(t, client, old.key("card")))
+	owner.mutex.RLock()
+	_, named := owner.store.stored("card")
+	item, _ := owner.store.get(old.key("card"))
+	owner.mutex.RUnlock()
+	if named || bytes.Contains(item.Value, []byte("4111")) {
+		t.Fatal("node holds the name or value in plain text")
+	}
+
+	both, _ := client.WithEncryption(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey, 2: newKey}, Current: 2})
+	if err = both.Rotate("card"); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := client.WithEncryption(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{2: newKey}, Current: 2})
+	value, err := current.Get("card")
+	if err != nil || string(value) != "4111" {
+		t.Fatalf("read after rotation %q, %v", value, err)
+	}
+	if _, err = old.Get("card"); err != ErrUnknownEncryptionKey {
+		t.Errorf("read with the rotated key: %v", err)
+	}
+
+	// rotating again changes nothing
+	rotated, err := current.GetItem("card")
+	if err != nil || rotated.Version <= item.Version {
+		t.Fatalf("rotated item %+v, %v", rotated, err)
+	}
+	if err = both.Rotate("card"); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := current.GetItem("card"); again.Version != rotated.Version {
+		t.Errorf("version %d after rotating twice, %d once", again.Version, rotated.Version)
+	}
+}
+
+func TestRotateKeepsTTL(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	old, _ := client.WithEncryption(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey}, Current: 1})
+	if _, err := old.PutWithTTL("session", []byte("token"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	item, err := old.GetItem("session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	both, _ := client.WithEncryption(Encryption{NameKey: nameKey, Keys: map[uint32][]byte{1: oldKey, 2: newKey}, Current: 2})
+	if err = both.Rotate("session"); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := both.GetItem("session")
+	if err != nil || rotated.Version <= item.Version {
+		t.Fatalf("rotated item %+v, %v", rotated, err)
+	}
+	if rotated.Expires.IsZero() || rotated.Expires.After(item.Expires.Add(time.Second)) {
+		t.Errorf("expires at %v after rotation, was %v", rotated.Expires, item.Expires)
+	}
+}
+
+// Returns the address of the owner of key
+func mustLookup(t *testing.T, client *Client, key string) string {
+	lookup, err := client.Lookup(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lookup.Address
+}
@@ -0,0 +1,109 @@
+package chord
+
+import (
+	"database/sql"
+)
+
+// Engine persists the Items of a node, see Config.Engine. The
+// node keeps every Item in memory as well, writes each change
+// through to the engine and reads the engine back when it starts.
+// Records are stored under the hex encoded id of their Key on the
+// ring. The Key itself is part of the record, which is encrypted
+// before it reaches the engine when Config.MasterKeyFile is set.
+type Engine interface {
+	// Get returns the record stored under id,
+	// nil if there is none
+	Get(id string) ([]byte, error)
+
+	// Put saves record under id, replacing
+	// the record stored under it if any
+	Put(id string, record []byte) error
+
+	// Delete removes the record stored under id
+	Delete(id string) error
+
+	// Load calls f with every record stored
+	Load(f func(id string, record []byte) error) error
+
+	Close() error
+}
+
+// sqliteEngine is an Engine storing records
+// in a table of a SQLite database
+type sqliteEngine struct {
+	db *sql.DB
+}
+
+// NewSQLiteEngine returns an Engine storing records in the
+// SQLite database at path, which is created if needed
+func NewSQLiteEngine(path string) (Engine, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_synchronous=NORMAL")
+	if err != nil {
+		return nil, err
+	}
+
+	// writes are made one at a time by the node
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS items (id TEXT PRIMARY KEY, record BLOB NOT NULL)"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteEngine{db}, nil
+}
+
+func (e *sqliteEngine) Get(id string) ([]byte, error) {
+	var record []byte
+	err := e.db.QueryRow("SELECT record FROM items WHERE id=?", id).Scan(&record)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return record, err
+}
+
+func (e *sqliteEngine) Put(id string, record []byte) error {
+	_, err := e.db.Exec("INSERT OR REPLACE INTO items(id, record) VALUES(?,?)", id, record)
+	return err
+}
+
+func (e *sqliteEngine) Delete(id string) error {
+	_, err := e.db.Exec("DELETE FROM items WHERE id=?", id)
+	return err
+}
+
+func (e *sqliteEngine) Load(f func(id string, record []byte) error) error {
+	rows, err := e.db.Query("SELECT id, record FROM items")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// records are read before f is called, so
+	// that f may write to the engine
+	type row struct {
+		id     string
+		record []byte
+	}
+	all := make([]row, 0)
+	for rows.Next() {
+		var r row
+		if err = rows.Scan(&r.id, &r.record); err != nil {
+			return err
+		}
+		all = append(all, r)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, r := range all {
+		if err = f(r.id, r.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *sqliteEngine) Close() error {
+	return e.db.Close()
+}
@@ -0,0 +1,345 @@
+package chord
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of records in an Engine. Plain records are the gob
+// encoded TransferItem. Sealed records are the format, the id of
+// the master key, the length of the wrapped data key, the wrapped
+// data key and the sealed TransferItem in that order. Each record
+// is sealed with a data key of its own, which is sealed in turn,
+// wrapped, with the master key.
+const (
+	recordPlain  = 0
+	recordSealed = 1
+)
+
+// Size in bytes of the data keys records are sealed with
+const dataKeySize = 32
+
+// masterKeys are the keys records are sealed with
+// before they reach the Engine, by id
+type masterKeys struct {
+	aeads   map[uint32]cipher.AEAD
+	current uint32
+}
+
+// Reads the master keys from the file at path. Each line of the
+// file holds the id of a key and the hex encoded AES key of 16, 24
+// or 32 bytes, separated by a space. Empty lines and lines starting
+// with # are skipped. The key with the highest id is current, so
+// that keys are rotated by adding a line to the file.
+func readMasterKeys(path string) (*masterKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := &masterKeys{aeads: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, ErrInvalidMasterKey
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, ErrInvalidMasterKey
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, ErrInvalidMasterKey
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, ErrInvalidMasterKey
+		}
+
+		keys.aeads[uint32(id)] = aead
+		if uint32(id) > keys.current {
+			keys.current = uint32(id)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys.aeads) == 0 {
+		return nil, ErrInvalidMasterKey
+	}
+	return keys, nil
+}
+
+// Returns AES-GCM with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts plain with aead under a random nonce, which is
+// prepended. The id of the record is authenticated so that
+// records cannot be swapped.
+func sealWith(aead cipher.AEAD, id string, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, []byte(id)), nil
+}
+
+// Decrypts what sealWith encrypted
+func openWith(aead cipher.AEAD, id string, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCorruptRecord
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, ErrCorruptRecord
+	}
+	return plain, nil
+}
+
+// Returns the record of the Item of key stored under id,
+// sealed with a new data key if keys is not nil
+func (keys *masterKeys) seal(id, key string, item Item) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(recordPlain)
+	if err := gob.NewEncoder(&buf).Encode(TransferItem{key, item}); err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return buf.Bytes(), nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealWith(aead, id, buf.Bytes()[1:])
+	if err != nil {
+		return nil, err
+	}
+	return keys.wrap(id, dataKey, sealed)
+}
+
+// Returns the sealed record of a data key and what it sealed,
+// the data key being wrapped with the current master key
+func (keys *masterKeys) wrap(id string, dataKey, sealed []byte) ([]byte, error) {
+	wrapped, err := sealWith(keys.aeads[keys.current], id, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, 7, 7+len(wrapped)+len(sealed))
+	record[0] = recordSealed
+	binary.BigEndian.PutUint32(record[1:5], keys.current)
+	binary.BigEndian.PutUint16(record[5:7], uint16(len(wrapped)))
+	record = append(record, wrapped...)
+	return append(record, sealed...), nil
+}
+
+// Splits a sealed record into the id of its master key,
+// its wrapped data key and what the data key sealed
+func splitRecord(record []byte) (uint32, []byte, []byte, error) {
+	if len(record) < 7 || record[0] != recordSealed {
+		return 0, nil, nil, ErrCorruptRecord
+	}
+	size := int(binary.BigEndian.Uint16(record[5:7]))
+	if len(record) < 7+size {
+		return 0, nil, nil, ErrCorruptRecord
+	}
+	return binary.BigEndian.Uint32(record[1:5]), record[7 : 7+size], record[7+size:], nil
+}
+
+// Returns the data key of a sealed record
+func (keys *masterKeys) unwrap(id string, record []byte) ([]byte, []byte, error) {
+	keyId, wrapped, sealed, err := splitRecord(record)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keys == nil || keys.aeads[keyId] == nil {
+		return nil, nil, ErrUnknownMasterKey
+	}
+	dataKey, err := openWith(keys.aeads[keyId], id, wrapped)
+	return dataKey, sealed, err
+}
+
+// Returns the Key and Item of the record stored under id
+func (keys *masterKeys) open(id string, record []byte) (TransferItem, error) {
+	var ti TransferItem
+	if len(record) == 0 {
+		return ti, ErrCorruptRecord
+	}
+
+	plain := record[1:]
+	if record[0] != recordPlain {
+		dataKey, sealed, err := keys.unwrap(id, record)
+		if err != nil {
+			return ti, err
+		}
+		aead, err := newAEAD(dataKey)
+		if err != nil {
+			return ti, ErrCorruptRecord
+		}
+		if plain, err = openWith(aead, id, sealed); err != nil {
+			return ti, err
+		}
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&ti); err != nil {
+		return ti, ErrCorruptRecord
+	}
+	return ti, nil
+}
+
+// Check if record is sealed with a data key
+// wrapped with the current master key
+func (keys *masterKeys) sealedWithCurrent(record []byte) bool {
+	keyId, _, _, err := splitRecord(record)
+	return err == nil && keyId == keys.current
+}
+
+// Returns the record stored under id with its data key wrapped
+// with the current master key, nil if it already is. Plain
+// records are sealed. What the data key sealed is left as is.
+func (keys *masterKeys) rewrap(id string, record []byte) ([]byte, error) {
+	if len(record) > 0 && record[0] == recordPlain {
+		ti, err := keys.open(id, record)
+		if err != nil {
+			return nil, err
+		}
+		return keys.seal(id, ti.Key, ti.Item)
+	}
+
+	if keys.sealedWithCurrent(record) {
+		return nil, nil
+	}
+	dataKey, sealed, err := keys.unwrap(id, record)
+	if err != nil {
+		return nil, err
+	}
+	return keys.wrap(id, dataKey, sealed)
+}
+
+// Reads back the Items persisted by Config.Engine,
+// if set, when the node starts
+func (node *Node) openStore() error {
+	if node.config.Engine == nil {
+		return nil
+	}
+	node.store.engine = node.config.Engine
+	node.store.failed = func(key string, err error) {
+		node.logger.Error("unable to persist key", "key", key, "err", err)
+	}
+
+	if path := node.config.MasterKeyFile; path != "" {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if node.store.keys, err = readMasterKeys(path); err != nil {
+			return err
+		}
+		node.masterKeysRead = stat.ModTime()
+	}
+
+	if err := node.store.load(); err != nil {
+		return err
+	}
+	node.logger.Info("loaded persisted keys", "keys", node.store.len(), "encrypted", node.store.keys != nil)
+	return nil
+}
+
+// Reads Config.MasterKeyFile again if it has changed since it
+// was last read, and rewraps every record whose data key is not
+// wrapped with the current master key. Called periodically, so
+// that master keys are rotated by editing the file while the
+// node keeps serving.
+func (node *Node) rotateMasterKeys() {
+	path := node.config.MasterKeyFile
+	if path == "" {
+		return
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		node.logger.Error("unable to read master keys", "file", path, "err", err)
+		return
+	}
+
+	node.mutex.Lock()
+	if node.store.engine == nil || node.rotating || (stat.ModTime().Equal(node.masterKeysRead) && !node.store.stale) {
+		node.mutex.Unlock()
+		return
+	}
+	if !stat.ModTime().Equal(node.masterKeysRead) {
+		keys, err := readMasterKeys(path)
+		if err != nil {
+			node.mutex.Unlock()
+			node.logger.Error("unable to read master keys", "file", path, "err", err)
+			return
+		}
+		node.store.stale = node.store.stale || node.store.keys == nil || keys.current != node.store.keys.current
+		node.store.keys = keys
+		node.masterKeysRead = stat.ModTime()
+	}
+	if !node.store.stale {
+		node.mutex.Unlock()
+		return
+	}
+	node.rotating = true
+	current := node.store.keys.current
+	keys := make([]string, 0, node.store.len())
+	node.store.each(func(key string, _ Item) {
+		keys = append(keys, key)
+	})
+	node.mutex.Unlock()
+
+	go func() {
+		start := time.Now()
+		rewrapped, failed := 0, 0
+
+		// records are rewrapped one at a time so
+		// that writes go on meanwhile
+		for _, key := range keys {
+			node.mutex.Lock()
+			done, err := node.store.rewrap(key)
+			node.mutex.Unlock()
+			if err != nil {
+				node.logger.Error("unable to rewrap record", "key", key, "err", err)
+				failed++
+			} else if done {
+				rewrapped++
+			}
+		}
+
+		node.mutex.Lock()
+		node.rotating = false
+		node.store.stale = failed > 0 || node.store.keys.current != current
+		node.mutex.Unlock()
+		node.logger.Info("rewrapped records with master key", "key", current, "records", rewrapped,
+			"failed", failed, "took", time.Since(start))
+	}()
+}
@@ -0,0 +1,185 @@
+package chord
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	masterKey1 = "1 0101010101010101010101010101010101010101010101010101010101010101\n"
+	masterKey2 = "2 02020202020202020202020202020202\n"
+)
+
+// Writes lines to a master key file in dir and returns its path
+func writeMasterKeys(t *testing.T, dir string, lines ...string) string {
+	path := filepath.Join(dir, "master-keys")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadMasterKeys(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := readMasterKeys(writeMasterKeys(t, dir, "# comment\n", masterKey2, "\n", masterKey1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.current != 2 || len(keys.aeads) != 2 {
+		t.Errorf("current key %d of %d", keys.current, len(keys.aeads))
+	}
+
+	for _, invalid := range []string{"", "1\n", "x 0101\n", "1 zz\n", "1 0101\n"} {
+		if _, err = readMasterKeys(writeMasterKeys(t, dir, invalid)); err != ErrInvalidMasterKey {
+			t.Errorf("read %q: %v", invalid, err)
+		}
+	}
+}
+
+func TestSealedRecords(t *testing.T) {
+	dir := t.TempDir()
+	old, _ := readMasterKeys(writeMasterKeys(t, dir, masterKey1))
+	both, _ := readMasterKeys(writeMasterKeys(t, dir, masterKey1, masterKey2))
+	current, _ := readMasterKeys(writeMasterKeys(t, dir, masterKey2))
+
+	item := Item{Value: []byte("secret value"), Version: 3}
+	id := recordId("key")
+	record, err := old.seal(id, "key", item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(record, item.Value) || bytes.Contains(record, []byte("key")) {
+		t.Fatal("record holds the key or value in plain text")
+	}
+
+	ti, err := old.open(id, record)
+	if err != nil || ti.Key != "key" || string(ti.Item.Value) != "secret value" || ti.Item.Version != 3 {
+		t.Fatalf("opened %+v, %v", ti, err)
+	}
+	if _, err = old.open(recordId("other"), record); err != ErrCorruptRecord {
+		t.Errorf("record moved to another id opened: %v", err)
+	}
+	if _, err = current.open(id, record); err != ErrUnknownMasterKey {
+		t.Errorf("record of a missing master key opened: %v", err)
+	}
+
+	// rewrapping changes the master key, not the sealed data
+	rewrapped, err := both.rewrap(id, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(rewrapped, record[len(record)-len(item.Value):]) {
+		t.Error("data sealed again")
+	}
+	if ti, err = current.open(id, rewrapped); err != nil || string(ti.Item.Value) != "secret value" {
+		t.Fatalf("opened rewrapped record %+v, %v", ti, err)
+	}
+	if again, err := both.rewrap(id, rewrapped); again != nil || err != nil {
+		t.Errorf("record of the current key rewrapped: %v", err)
+	}
+
+	// plain records are sealed
+	var plain *masterKeys
+	record, _ = plain.seal(id, "key", item)
+	if record[0] != recordPlain {
+		t.Fatal("record sealed without master keys")
+	}
+	if rewrapped, err = current.rewrap(id, record); err != nil || !current.sealedWithCurrent(rewrapped) {
+		t.Errorf("plain record not sealed: %v", err)
+	}
+}
+
+// Starts a node persisting to the database at path with
+// the master keys at keyFile, which may be empty
+func startPersistentNode(t *testing.T, address, path, keyFile string) (*RPCNode, error) {
+	engine, err := NewSQLiteEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(address, "")
+	config.Engine = engine
+	config.MasterKeyFile = keyFile
+	node, err := CreateNewNodeWithConfig(config)
+	if err != nil {
+		engine.Close()
+	}
+	return node, err
+}
+
+func TestPersistedKeysSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "node.db")
+	keyFile := writeMasterKeys(t, dir, masterKey1)
+	address := freeAddress(t)
+
+	node, err := startPersistentNode(t, address, path, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.mutex.Lock()
+	node.store.set("kept", []byte("secret value"), time.Time{})
+	node.store.set("deleted", []byte("value"), time.Time{})
+	node.store.del([]string{"deleted"})
+	node.mutex.Unlock()
+
+	// rotate to the second key while the node runs
+	writeMasterKeys(t, dir, masterKey1, masterKey2)
+	node.mutex.Lock()
+	node.masterKeysRead = time.Time{}
+	node.mutex.Unlock()
+	node.rotateMasterKeys()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		node.mutex.RLock()
+		done := !node.rotating && !node.store.stale
+		node.mutex.RUnlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("records not rewrapped")
+		}
+	}
+	node.Stop()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wal, err := ioutil.ReadFile(path + "-wal"); err == nil {
+		data = append(data, wal...)
+	}
+	if bytes.Contains(data, []byte("secret value")) {
+		t.Error("value written to disk in plain text")
+	}
+
+	// the first key is no longer needed
+	writeMasterKeys(t, dir, masterKey2)
+	node, err = startPersistentNode(t, address, path, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.mutex.RLock()
+	item, ok := node.store.get("kept")
+	_, deleted := node.store.stored("deleted")
+	node.mutex.RUnlock()
+	node.Stop()
+	if !ok || string(item.Value) != "secret value" {
+		t.Errorf("kept = %q, %v after restart", item.Value, ok)
+	}
+	if deleted {
+		t.Error("deleted key back after restart")
+	}
+
+	// nor does a node start without the key of its records
+	writeMasterKeys(t, dir, "3 03030303030303030303030303030303\n")
+	if node, err = startPersistentNode(t, address, path, keyFile); err != ErrUnknownMasterKey {
+		if err == nil {
+			node.Stop()
+		}
+		t.Errorf("started without the master key: %v", err)
+	}
+}
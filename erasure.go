@@ -0,0 +1,466 @@
+package chord
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"time"
+)
+
+// Erasure describes a Value stored as fragments, in
+// place of the Value, see Config.ErasureThreshold
+type Erasure struct {
+	// identifies the write of the Value, fragments
+	// of every write to a Key are stored apart
+	Id string
+
+	// length of the Value in bytes
+	Size int
+
+	// number of data and parity fragments, any
+	// Data fragments rebuild the Value
+	Data   int
+	Parity int
+
+	// address of the node holding each fragment
+	// and the sha1 hash of the fragment
+	Holders []string
+	Hashes  [][]byte
+
+	// number of times Holders were repaired, of two
+	// copies of an Item the more repaired one wins
+	Repaired int
+}
+
+// Fragment is one fragment of a Value
+type Fragment struct {
+	Key   string
+	Id    string
+	Index int
+	Data  []byte
+
+	// time after which the fragment is
+	// deleted, zero if it never expires
+	Expires time.Time
+}
+
+// fragmentId identifies a fragment held by a node
+type fragmentId struct {
+	key   string
+	id    string
+	index int
+}
+
+// storedFragment is a fragment held by a node
+type storedFragment struct {
+	data    []byte
+	expires time.Time
+}
+
+// Check if the Value of a write is to be erasure coded.
+// Siblings of vector clocks and blobs are always stored whole.
+func (node *Node) erasureCoded(req *WriteRequest) bool {
+	threshold := node.config.ErasureThreshold
+	if _, blob := blobOf(req.Key); blob {
+		// the owner checks blobs against their hash
+		return false
+	}
+	return threshold > 0 && !req.Delete && len(req.Value) > threshold && !node.config.VectorClocks
+}
+
+// Returns the nodes holding the fragments of a Key
+// owned by owner, the owner and the nodes following
+// it found by successive lookups. Fragments wrap
+// around rings with fewer nodes than fragments.
+func (node *Node) fragmentHolders(owner Lookup, count int) []string {
+	holders := []string{owner.Address}
+	for len(holders) < count {
+		next := node.resolve(fingerId(owner.Id, 0))
+		if next.Address == "" {
+			next = owner
+		}
+		holders = append(holders, next.Address)
+		owner = next
+	}
+	return holders
+}
+
+// Splits the Value of a write into fragments and stores
+// them on the owner of its Key and the nodes following
+// it. Returns the Erasure to be written in its place
+// and the number of fragments stored.
+func (node *Node) storeFragments(owner Lookup, req *WriteRequest) (*Erasure, int, error) {
+	data, parity := node.config.DataFragments, node.config.ParityFragments
+	rs, err := newReedSolomon(data, parity)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fragments := rs.encode(req.Value)
+	erasure := &Erasure{
+		Id:      fmt.Sprintf("%s-%d", node.address, time.Now().UnixNano()),
+		Size:    len(req.Value),
+		Data:    data,
+		Parity:  parity,
+		Holders: node.fragmentHolders(owner, len(fragments)),
+		Hashes:  make([][]byte, len(fragments)),
+	}
+
+	var expires time.Time
+	if req.TTL > 0 {
+		expires = time.Now().Add(req.TTL)
+	}
+
+	stored := make(chan error, len(fragments))
+	for i, fragment := range fragments {
+		hash := sha1.Sum(fragment)
+		erasure.Hashes[i] = hash[:]
+
+		go func(fragment Fragment, holder string) {
+			holderRPC, err := node.getClient(holder)
+			if err != nil {
+				stored <- err
+				return
+			}
+			defer holderRPC.Close()
+
+			var reply string
+			stored <- holderRPC.Call("RPCNode.StoreFragment", &fragment, &reply)
+		}(Fragment{req.Key, erasure.Id, i, fragment, expires}, erasure.Holders[i])
+	}
+
+	// enough fragments to rebuild the Value, more
+	// at higher consistency levels
+	need := data
+	switch req.Consistency {
+	case ConsistencyQuorum:
+		need += parity / 2
+	case ConsistencyAll, ConsistencyLinearizable:
+		need += parity
+	}
+
+	count := 0
+	for range fragments {
+		if err := <-stored; err != nil {
+			node.logger.Warn("unable to store fragment", "key", req.Key, "err", err)
+			continue
+		}
+		count++
+	}
+	if count < need {
+		go node.dropFragments(req.Key, erasure)
+		return nil, count, ErrNotEnoughFragments
+	}
+	return erasure, count, nil
+}
+
+// Rebuilds the Value of an erasure coded Item from
+// the fragments of it which can be read
+func (node *Node) rebuild(key string, item *Item) error {
+	erasure := item.Erasure
+	rs, err := newReedSolomon(erasure.Data, erasure.Parity)
+	if err != nil {
+		return err
+	}
+
+	type read struct {
+		index int
+		data  []byte
+	}
+	reads := make(chan read, len(erasure.Holders))
+	for i, holder := range erasure.Holders {
+		go func(index int, holder string) {
+			holderRPC, err := node.getClient(holder)
+			if err != nil {
+				reads <- read{index, nil}
+				return
+			}
+			defer holderRPC.Close()
+
+			var data []byte
+			err = holderRPC.Call("RPCNode.GetFragment", &Fragment{Key: key, Id: erasure.Id, Index: index}, &data)
+			if err != nil {
+				data = nil
+			}
+			reads <- read{index, data}
+		}(i, holder)
+	}
+
+	// fragments which do not match their hash are
+	// stale or corrupt and treated as missing
+	fragments := make([][]byte, len(erasure.Holders))
+	found := 0
+	for range erasure.Holders {
+		r := <-reads
+		if r.data == nil {
+			continue
+		}
+		if hash := sha1.Sum(r.data); !bytes.Equal(hash[:], erasure.Hashes[r.index]) {
+			node.logger.Warn("fragment does not match its hash", "key", key, "index", r.index, "holder", erasure.Holders[r.index])
+			continue
+		}
+		fragments[r.index] = r.data
+		found++
+		if found == erasure.Data {
+			break
+		}
+	}
+
+	item.Value, err = rs.decode(fragments, erasure.Size)
+	return err
+}
+
+// Deletes the fragments of erasure from their holders
+func (node *Node) dropFragments(key string, erasure *Erasure) {
+	for i, holder := range erasure.Holders {
+		holderRPC, err := node.getClient(holder)
+		if err != nil {
+			continue
+		}
+		var reply string
+		err = holderRPC.Call("RPCNode.DropFragment", &Fragment{Key: key, Id: erasure.Id, Index: i}, &reply)
+		holderRPC.Close()
+		if err != nil {
+			node.logger.Debug("unable to drop fragment", "key", key, "index", i, "holder", holder, "err", err)
+		}
+	}
+}
+
+// Sends the fragments held for the keys transferred to the
+// node with id toID at address "to", in batches of at most
+// Config.TransferChunkSize bytes. Fragments are deleted from
+// this node once the receiver holds them.
+func (node *Node) transferFragments(to string, toID []byte) error {
+	node.mutex.RLock()
+	all := node.transfersAll(toID)
+	fragments := make([]Fragment, 0)
+	for id, stored := range node.fragments {
+		if all || !betweenRightInc(getHash(id.key), toID, node.id) {
+			fragments = append(fragments, Fragment{id.key, id.id, id.index, stored.data, stored.expires})
+		}
+	}
+	node.mutex.RUnlock()
+	if len(fragments) == 0 {
+		return nil
+	}
+
+	toRPC, err := node.getClient(to)
+	if err != nil {
+		return err
+	}
+	defer toRPC.Close()
+
+	for len(fragments) > 0 {
+		end, size := 0, 0
+		for end < len(fragments) && (end == 0 || size+fragments[end].size() <= node.config.TransferChunkSize) {
+			size += fragments[end].size()
+			end++
+		}
+		batch := fragments[:end]
+
+		var reply string
+		if err = toRPC.Call("RPCNode.StoreFragments", &batch, &reply); err != nil {
+			return err
+		}
+		node.mutex.Lock()
+		for _, fragment := range batch {
+			node.dropFragment(fragmentId{fragment.Key, fragment.Id, fragment.Index})
+		}
+		node.mutex.Unlock()
+		fragments = fragments[end:]
+	}
+	return nil
+}
+
+// Check if holder holds fragment index of the Value of key
+// as described by erasure
+func (node *Node) holdsFragment(holder, key string, erasure *Erasure, index int) bool {
+	holderRPC, err := node.getClient(holder)
+	if err != nil {
+		return false
+	}
+	defer holderRPC.Close()
+
+	var hash []byte
+	err = holderRPC.Call("RPCNode.FragmentHash", &Fragment{Key: key, Id: erasure.Id, Index: index}, &hash)
+	return err == nil && bytes.Equal(hash, erasure.Hashes[index])
+}
+
+// Checks that the fragments of the erasure coded Items the node
+// owns are held where their Erasure says. A missing fragment is
+// looked for on the node which would hold it now, as fragments
+// move along with keys, and is else rebuilt from the others and
+// stored there. Items whose holders changed are replicated.
+func (node *Node) repairFragments() {
+	node.mutex.RLock()
+	start, self := node.predecessorId, Lookup{Address: node.address, Id: node.id}
+	coded := make([]TransferItem, 0)
+	node.store.each(func(key string, item Item) {
+		if item.Erasure != nil && !item.Deleted && (start == nil || betweenRightInc(getHash(key), start, node.id)) {
+			coded = append(coded, TransferItem{key, item})
+		}
+	})
+	node.mutex.RUnlock()
+
+	// holders of fragments of keys owned by this
+	// node now, by the number of fragments
+	placements := make(map[int][]string)
+
+	repaired := make([]string, 0)
+	for _, ti := range coded {
+		erasure := *ti.Item.Erasure
+		erasure.Holders = append([]string(nil), erasure.Holders...)
+		placement, ok := placements[len(erasure.Holders)]
+		if !ok {
+			placement = node.fragmentHolders(self, len(erasure.Holders))
+			placements[len(erasure.Holders)] = placement
+		}
+
+		var fragments [][]byte
+		changed := false
+		for i, holder := range erasure.Holders {
+			if node.holdsFragment(holder, ti.Key, ti.Item.Erasure, i) {
+				continue
+			}
+			if placement[i] != holder && node.holdsFragment(placement[i], ti.Key, ti.Item.Erasure, i) {
+				erasure.Holders[i], changed = placement[i], true
+				continue
+			}
+
+			if fragments == nil {
+				item := ti.Item
+				if err := node.rebuild(ti.Key, &item); err != nil {
+					node.logger.Warn("unable to rebuild value to repair fragments", "key", ti.Key, "err", err)
+					break
+				}
+				rs, err := newReedSolomon(erasure.Data, erasure.Parity)
+				if err != nil {
+					break
+				}
+				fragments = rs.encode(item.Value)
+			}
+
+			placementRPC, err := node.getClient(placement[i])
+			if err != nil {
+				continue
+			}
+			var reply string
+			err = placementRPC.Call("RPCNode.StoreFragment", &Fragment{ti.Key, erasure.Id, i, fragments[i], ti.Item.Expires}, &reply)
+			placementRPC.Close()
+			if err != nil {
+				node.logger.Warn("unable to repair fragment", "key", ti.Key, "index", i, "holder", placement[i], "err", err)
+				continue
+			}
+			erasure.Holders[i], changed = placement[i], true
+			node.metrics.fragmentsRepaired.inc()
+		}
+		if !changed {
+			continue
+		}
+
+		// the Item is updated only if it was not
+		// written meanwhile
+		erasure.Repaired++
+		node.mutex.Lock()
+		if current, ok := node.store.stored(ti.Key); ok && current.Version == ti.Item.Version &&
+			current.Erasure != nil && current.Erasure.Id == erasure.Id {
+			current.Erasure = &erasure
+			node.store.put(ti.Key, current)
+			repaired = append(repaired, ti.Key)
+		}
+		node.mutex.Unlock()
+	}
+	if len(repaired) == 0 {
+		return
+	}
+
+	node.mutex.RLock()
+	items := node.itemsOf(repaired...)
+	node.mutex.RUnlock()
+	node.replicate(items, ConsistencyOne)
+	node.logger.Debug("repaired fragments", "keys", len(repaired))
+}
+
+// Deletes the fragments which have expired by given
+// time. node.mutex must be held by the caller.
+func (node *Node) expireFragments(now time.Time) {
+	for id, fragment := range node.fragments {
+		if !fragment.expires.IsZero() && !now.Before(fragment.expires) {
+			node.dropFragment(id)
+		}
+	}
+}
+
+// Saves a fragment, keeping count of the bytes of
+// fragments held. node.mutex must be held by the caller.
+func (node *Node) putFragment(id fragmentId, fragment storedFragment) {
+	node.fragmentBytes += len(fragment.data) - len(node.fragments[id].data)
+	node.fragments[id] = fragment
+}
+
+// Deletes a fragment, keeping count of the bytes of
+// fragments held. node.mutex must be held by the caller.
+func (node *Node) dropFragment(id fragmentId) {
+	node.fragmentBytes -= len(node.fragments[id].data)
+	delete(node.fragments, id)
+}
+
+// Saves a fragment of a Value
+func (node *RPCNode) StoreFragment(fragment *Fragment, _ *string) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	id := fragmentId{fragment.Key, fragment.Id, fragment.Index}
+	if err := node.checkCapacity(len(fragment.Data) - len(node.fragments[id].data)); err != nil {
+		return err
+	}
+	node.putFragment(id, storedFragment{fragment.Data, fragment.Expires})
+	return nil
+}
+
+// Saves fragments transferred from another node
+// along with the range of keys they belong to
+func (node *RPCNode) StoreFragments(fragments *[]Fragment, _ *string) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	for _, fragment := range *fragments {
+		node.putFragment(fragmentId{fragment.Key, fragment.Id, fragment.Index}, storedFragment{fragment.Data, fragment.Expires})
+	}
+	return nil
+}
+
+// Replies with the data of a fragment
+func (node *RPCNode) GetFragment(fragment *Fragment, data *[]byte) error {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	stored, ok := node.fragments[fragmentId{fragment.Key, fragment.Id, fragment.Index}]
+	if !ok {
+		return ErrNoKeyValuePair
+	}
+	*data = stored.data
+	return nil
+}
+
+// Replies with the sha1 hash of the data of a fragment
+func (node *RPCNode) FragmentHash(fragment *Fragment, hash *[]byte) error {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	stored, ok := node.fragments[fragmentId{fragment.Key, fragment.Id, fragment.Index}]
+	if !ok {
+		return ErrNoKeyValuePair
+	}
+	sum := sha1.Sum(stored.data)
+	*hash = sum[:]
+	return nil
+}
+
+// Deletes a fragment
+func (node *RPCNode) DropFragment(fragment *Fragment, _ *string) error {
+	node.mutex.Lock()
+	node.dropFragment(fragmentId{fragment.Key, fragment.Id, fragment.Index})
+	node.mutex.Unlock()
+	return nil
+}
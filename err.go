@@ -1,6 +1,9 @@
 package chord
 
-import "errors"
+import (
+	"errors"
+	"net/rpc"
+)
 
 var (
 	ErrUnableToListen    = errors.New("error: rpc server unable to listen on specified addr:port")
@@ -9,4 +12,88 @@ var (
 	ErrNodeAlreadyExists = errors.New("error: node with same id already exists")
 	ErrNoKeyValuePair    = errors.New("error: key value pair not found")
 	ErrNilPredecessor    = errors.New("error: predecessor does not exists")
+	ErrVersionMismatch   = errors.New("error: version of key does not match expected version")
+	ErrChecksumMismatch  = errors.New("error: checksum of transferred chunk does not match")
+
+	ErrInvalidMerkleRequest = errors.New("error: requested merkle tree nodes do not exist")
+	ErrNotEnoughReplicas    = errors.New("error: not enough replicas answered")
+	ErrTooManyHints         = errors.New("error: node holds too many hints for unreachable nodes")
+	ErrInvalidFragments     = errors.New("error: invalid number of data or parity fragments")
+	ErrNotEnoughFragments   = errors.New("error: not enough fragments of value could be reached")
+	ErrUnauthenticated      = errors.New("error: unable to prove membership of the ring")
+	ErrUnauthorized         = errors.New("error: method may only be called by members of the ring")
+	ErrInvalidIdentity      = errors.New("error: identity of node could not be verified")
+	ErrOverloaded           = errors.New("error: node is overloaded, retry later")
+	ErrAccessDenied         = errors.New("error: access to namespace denied")
+	ErrQuotaExceeded        = errors.New("error: quota of namespace exceeded")
+	ErrInvalidEncryptionKey = errors.New("error: invalid encryption key")
+	ErrUnknownEncryptionKey = errors.New("error: value encrypted with unknown key")
+	ErrDecryptionFailed     = errors.New("error: value could not be decrypted")
+	ErrSiblingsPresent      = errors.New("error: key has concurrent values which must be resolved first")
+	ErrInvalidMasterKey     = errors.New("error: invalid master key file")
+	ErrUnknownMasterKey     = errors.New("error: record sealed with unknown master key")
+	ErrCorruptRecord        = errors.New("error: persisted record is corrupt")
+	ErrKeyTooLarge          = errors.New("error: key exceeds the maximum key size")
+	ErrValueTooLarge        = errors.New("error: value exceeds the maximum value size")
+	ErrCapacityExceeded     = errors.New("error: node has no capacity left for the write")
+	ErrBlobMismatch         = errors.New("error: blob does not match its hash")
+	ErrInvalidBatchReply    = errors.New("error: reply of owner does not match the batch sent")
+	ErrMessageTooLarge      = errors.New("error: message exceeds the maximum message size")
+
+	ErrNamespacesWithoutAuth = errors.New("error: namespaces require cluster key or certificate authentication")
 )
+
+// errors above by their message, errors returned by a node
+// reach the client as an rpc.ServerError holding the message
+var remoteErrors = make(map[string]error)
+
+func init() {
+	for _, err := range []error{
+		ErrUnableToListen,
+		ErrUnableToDial,
+		ErrFailedToReach,
+		ErrNodeAlreadyExists,
+		ErrNoKeyValuePair,
+		ErrNilPredecessor,
+		ErrVersionMismatch,
+		ErrChecksumMismatch,
+		ErrInvalidMerkleRequest,
+		ErrNotEnoughReplicas,
+		ErrTooManyHints,
+		ErrInvalidFragments,
+		ErrNotEnoughFragments,
+		ErrUnauthenticated,
+		ErrUnauthorized,
+		ErrInvalidIdentity,
+		ErrOverloaded,
+		ErrAccessDenied,
+		ErrQuotaExceeded,
+		ErrInvalidEncryptionKey,
+		ErrUnknownEncryptionKey,
+		ErrDecryptionFailed,
+		ErrSiblingsPresent,
+		ErrInvalidMasterKey,
+		ErrUnknownMasterKey,
+		ErrCorruptRecord,
+		ErrKeyTooLarge,
+		ErrValueTooLarge,
+		ErrCapacityExceeded,
+		ErrBlobMismatch,
+		ErrInvalidBatchReply,
+		ErrMessageTooLarge,
+		ErrNamespacesWithoutAuth,
+	} {
+		remoteErrors[err.Error()] = err
+	}
+}
+
+// Returns err as returned by the node if it is one of the
+// errors above, so that callers can compare it with them
+func remoteError(err error) error {
+	if serverErr, ok := err.(rpc.ServerError); ok {
+		if known, ok := remoteErrors[string(serverErr)]; ok {
+			return known
+		}
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package chord
+
+import "time"
+
+// hint is an Item held for a node which could not
+// be reached, replayed to it once it is back
+type hint struct {
+	item    Item
+	created time.Time
+
+	// the Item was stored on this node only because
+	// its owner could not be reached, it is deleted
+	// once replayed unless this node is a replica
+	handoff bool
+}
+
+// HintedWrite is a write to a Key whose
+// owner could not be reached
+type HintedWrite struct {
+	// address of the owner of the Key
+	Owner string
+
+	Write WriteRequest
+}
+
+// Saves hints of items for the node at address target.
+// Older hints of the same keys are replaced. Returns
+// ErrTooManyHints if the node holds Config.MaxHints
+// hints already. node.mutex must be held by the caller.
+func (node *Node) addHint(target string, items []TransferItem, handoff bool) error {
+	hints, ok := node.hints[target]
+	if !ok {
+		hints = make(map[string]hint)
+		node.hints[target] = hints
+	}
+
+	added := 0
+	for _, ti := range items {
+		if _, ok := hints[ti.Key]; !ok {
+			added++
+		}
+	}
+	if node.hintCount+added > node.config.MaxHints {
+		node.metrics.hintsDropped.add(len(items))
+		return ErrTooManyHints
+	}
+
+	for _, ti := range items {
+		hints[ti.Key] = hint{ti.Item, time.Now(), handoff}
+	}
+	node.hintCount += added
+	node.metrics.hintsStored.add(len(items))
+	return nil
+}
+
+// Returns the address of the first reachable node following
+// the owner found by lookup, which could not be reached. The
+// successor list is read from the last node on the lookup path,
+// the one which found the owner to be its successor.
+func (node *Node) nextLive(lookup Lookup) (string, error) {
+	if len(lookup.Path) == 0 {
+		return "", ErrFailedToReach
+	}
+	var successors []NodeRef
+	last := lookup.Path[len(lookup.Path)-1]
+	if last == node.address {
+		successors = node.successors()
+	} else {
+		lastRPC, err := node.getClient(last)
+		if err != nil {
+			return "", err
+		}
+		err = lastRPC.Call("RPCNode.GetSuccessorList", "", &successors)
+		lastRPC.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, successor := range successors {
+		if successor.Address == lookup.Address {
+			continue
+		}
+		if client, err := node.getClient(successor.Address); err == nil {
+			client.Close()
+			return successor.Address, nil
+		}
+	}
+	return "", ErrUnableToDial
+}
+
+// Applies a write whose owner could not be reached at the
+// next live node, which holds it as a hint for the owner
+func (node *Node) handoff(lookup Lookup, req *WriteRequest, result *WriteResult) error {
+	holder, err := node.nextLive(lookup)
+	if err != nil {
+		return err
+	}
+
+	holderRPC, err := node.getClient(holder)
+	if err != nil {
+		return err
+	}
+	defer holderRPC.Close()
+
+	node.logger.Info("owner unreachable, handing off write", "owner", lookup.Address, "to", holder, "key", req.Key)
+	return holderRPC.Call("RPCNode.HintWrite", &HintedWrite{lookup.Address, *req}, result)
+}
+
+// Sends the hints held for nodes which are reachable again
+// and drops those older than Config.HintTTL
+func (node *Node) replayHints() {
+	node.mutex.Lock()
+	pending := make(map[string][]TransferItem)
+	for target, hints := range node.hints {
+		for key, h := range hints {
+			if time.Since(h.created) > node.config.HintTTL {
+				delete(hints, key)
+				node.hintCount--
+				node.metrics.hintsDropped.inc()
+				continue
+			}
+			pending[target] = append(pending[target], TransferItem{key, h.item})
+		}
+		if len(hints) == 0 {
+			delete(node.hints, target)
+		}
+	}
+	node.mutex.Unlock()
+
+	for target, items := range pending {
+		if err := node.replayTo(target, items); err != nil {
+			node.logger.Debug("unable to replay hints", "to", target, "hints", len(items), "err", err)
+		}
+	}
+}
+
+// Replays hinted items to target and deletes the hints,
+// along with Items stored only because of them
+func (node *Node) replayTo(target string, items []TransferItem) error {
+	targetRPC, err := node.getClient(target)
+	if err != nil {
+		return err
+	}
+	defer targetRPC.Close()
+
+	if err = node.replicateTo(targetRPC, items); err != nil {
+		return err
+	}
+
+	// keep handed off Items this node is a replica of
+	var replicas []string
+	if err = targetRPC.Call("RPCNode.ReplicaSet", "", &replicas); err != nil {
+		return err
+	}
+	replica := false
+	for _, address := range replicas {
+		replica = replica || address == node.address
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	hints := node.hints[target]
+	for _, ti := range items {
+		h, ok := hints[ti.Key]
+		if !ok || h.item.Version != ti.Item.Version {
+			// hint was replaced meanwhile
+			continue
+		}
+		delete(hints, ti.Key)
+		node.hintCount--
+
+		if current, ok := node.store.stored(ti.Key); ok && h.handoff && !replica && current.Version == ti.Item.Version {
+			node.store.del([]string{ti.Key})
+		}
+	}
+	if len(hints) == 0 {
+		delete(node.hints, target)
+	}
+
+	node.metrics.hintsReplayed.add(len(items))
+	node.logger.Info("replayed hints", "to", target, "hints", len(items))
+	return nil
+}
+
+// Applies a write on behalf of the owner of its Key, which
+// could not be reached, and holds it as a hint for the owner
+func (node *RPCNode) HintWrite(req *HintedWrite, result *WriteResult) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if node.hintCount >= node.config.MaxHints {
+		node.metrics.hintsDropped.inc()
+		return ErrTooManyHints
+	}
+
+	version, err := node.applyWrite(&req.Write)
+	if err != nil {
+		return err
+	}
+	if err = node.addHint(req.Owner, node.itemsOf(req.Write.Key), true); err != nil {
+		return err
+	}
+
+	result.Version = version
+	result.Node = node.address
+	result.Replicas = 1
+	result.Hinted = true
+	return nil
+}
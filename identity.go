@@ -0,0 +1,150 @@
+package chord
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+// Announcements older than this, or dated further
+// than this in the future, are not accepted
+const announcementMaxAge = time.Minute
+
+// Announcement is the claim of a node to its address. When
+// Config.Identity is set it carries the public key of the
+// node, from which its id is derived, and is signed with it.
+type Announcement struct {
+	Address string
+
+	PublicKey ed25519.PublicKey
+	Signed    time.Time
+	Signature []byte
+}
+
+// Returns the bytes of ann which are signed
+func (ann *Announcement) message() []byte {
+	message := []byte("chord announcement\x00" + ann.Address + "\x00")
+	message = append(message, ann.PublicKey...)
+	signed := make([]byte, 8)
+	binary.BigEndian.PutUint64(signed, uint64(ann.Signed.UnixNano()))
+	return append(message, signed...)
+}
+
+// Returns the id of a node with given public key
+func identityId(key ed25519.PublicKey) []byte {
+	return getHash(string(key))
+}
+
+// Returns a fresh announcement of the node,
+// signed if the node has an identity
+func (node *Node) announce() *Announcement {
+	ann := &Announcement{Address: node.address}
+	if node.config.Identity == nil {
+		return ann
+	}
+	ann.PublicKey = node.config.Identity.Public().(ed25519.PublicKey)
+	ann.Signed = time.Now()
+	ann.Signature = ed25519.Sign(node.config.Identity, ann.message())
+	return ann
+}
+
+// Returns the id of the node which made ann if it claims
+// address, is recent and is signed with the key it carries
+func verifyAnnouncement(ann *Announcement, address string) ([]byte, error) {
+	age := time.Since(ann.Signed)
+	if ann.Address != address || len(ann.PublicKey) != ed25519.PublicKeySize ||
+		age > announcementMaxAge || age < -announcementMaxAge ||
+		!ed25519.Verify(ann.PublicKey, ann.message(), ann.Signature) {
+		return nil, ErrInvalidIdentity
+	}
+	return identityId(ann.PublicKey), nil
+}
+
+// Returns the id of the node at address which sent ann. Without
+// identities the node reached by client is asked for its id.
+func (node *Node) announcedId(client *rpc.Client, ann *Announcement) ([]byte, error) {
+	if node.config.Identity == nil {
+		var id []byte
+		err := client.Call("RPCNode.GetId", "", &id)
+		return id, err
+	}
+
+	id, err := verifyAnnouncement(ann, ann.Address)
+	if err != nil {
+		node.metrics.identityRejections.inc()
+		node.logger.Warn("rejected node with invalid identity", "node", ann.Address)
+	}
+	return id, err
+}
+
+// Returns the id of the node at address reached by client. With
+// identities the id is derived from a fresh announcement which
+// the node is asked for, and which must be signed by it.
+func (node *Node) peerId(client *rpc.Client, address string) ([]byte, error) {
+	if address == node.address {
+		return node.id, nil
+	}
+	if node.config.Identity == nil {
+		return node.announcedId(client, nil)
+	}
+
+	var ann Announcement
+	if err := client.Call("RPCNode.Identity", "", &ann); err != nil {
+		return nil, err
+	}
+	if ann.Address != address {
+		node.metrics.identityRejections.inc()
+		node.logger.Warn("rejected node with invalid identity", "node", address, "claimed", ann.Address)
+		return nil, ErrInvalidIdentity
+	}
+	return node.announcedId(client, &ann)
+}
+
+// LoadIdentity reads the identity of a node, an ed25519 key in
+// a PKCS #8 PEM file. If the file does not exist a new identity
+// is created and saved to it, so that the id of the node stays
+// the same across restarts.
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		return key, os.WriteFile(path, block, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	identity, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key in %s is not an ed25519 key", path)
+	}
+	return identity, nil
+}
+
+// Replies with a fresh announcement of the node
+func (node *RPCNode) Identity(_ *string, ann *Announcement) error {
+	*ann = *node.announce()
+	return nil
+}
@@ -0,0 +1,94 @@
+package chord
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns an announcement of address signed with key at signed
+func signedAnnouncement(key ed25519.PrivateKey, address string, signed time.Time) *Announcement {
+	ann := &Announcement{Address: address, PublicKey: key.Public().(ed25519.PublicKey), Signed: signed}
+	ann.Signature = ed25519.Sign(key, ann.message())
+	return ann
+}
+
+func TestVerifyAnnouncement(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "127.0.0.1:35383"
+
+	id, err := verifyAnnouncement(signedAnnouncement(key, address, time.Now()), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(id, identityId(key.Public().(ed25519.PublicKey))) {
+		t.Error("id is not derived from the public key")
+	}
+
+	if _, err = verifyAnnouncement(signedAnnouncement(key, address, time.Now()), "127.0.0.1:35384"); err != ErrInvalidIdentity {
+		t.Errorf("announcement of another address accepted: %v", err)
+	}
+	if _, err = verifyAnnouncement(signedAnnouncement(key, address, time.Now().Add(-time.Hour)), address); err != ErrInvalidIdentity {
+		t.Errorf("stale announcement accepted: %v", err)
+	}
+
+	// a node claiming the key of another cannot sign for it
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	forged := signedAnnouncement(other, address, time.Now())
+	forged.PublicKey = key.Public().(ed25519.PublicKey)
+	if _, err = verifyAnnouncement(forged, address); err != ErrInvalidIdentity {
+		t.Errorf("forged announcement accepted: %v", err)
+	}
+}
+
+func TestLoadIdentityKeepsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.pem")
+	created, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Equal(loaded) {
+		t.Error("identity changed once saved")
+	}
+}
+
+func TestRingWithIdentities(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := startRing(t, ca, 2, func(config *Config) {
+		_, config.Identity, _ = ed25519.GenerateKey(rand.Reader)
+	})
+	defer r.stop()
+
+	for _, node := range r.nodes {
+		if !bytes.Equal(node.id, identityId(node.config.Identity.Public().(ed25519.PublicKey))) {
+			t.Errorf("id of %s is not derived from its identity", node.address)
+		}
+	}
+
+	// a node claiming the address of another is rejected
+	client, err := getClient(r.nodes[1].address, r.nodes[0].credentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = r.nodes[0].peerId(client, "127.0.0.1:1"); err != ErrInvalidIdentity {
+		t.Errorf("node at another address accepted: %v", err)
+	}
+	id, err := r.nodes[0].peerId(client, r.nodes[1].address)
+	if err != nil || !bytes.Equal(id, r.nodes[1].id) {
+		t.Errorf("peer id = %x, %v", id, err)
+	}
+}
@@ -0,0 +1,178 @@
+package chord
+
+// Returns the size of the Value written by req
+func (req *WriteRequest) size() int {
+	if req.Erasure != nil {
+		return req.Erasure.Size
+	}
+	return len(req.Value)
+}
+
+// Returns the bytes the Value of item takes in
+// the store, which for erasure coded Values are
+// held as fragments instead
+func (item Item) footprint() int {
+	if len(item.Siblings) == 0 {
+		return len(item.Value)
+	}
+	size := 0
+	for _, sibling := range item.Siblings {
+		size += len(sibling.Value)
+	}
+	return size
+}
+
+// Bytes gob takes at most for the fields of fixed size of
+// a struct sent between nodes, along with the length prefixes
+// of its other fields
+const encodingOverhead = 64
+
+// Returns the bytes ti takes at most when gob encoded,
+// which messages of many Items are split by
+func (ti TransferItem) size() int {
+	return len(ti.Key) + ti.Item.encodedSize()
+}
+
+// Returns the bytes item takes at most when gob
+// encoded, its siblings and Erasure included
+func (item Item) encodedSize() int {
+	size := encodingOverhead + len(item.Value) + item.Erasure.encodedSize()
+	for _, sibling := range item.Siblings {
+		size += encodingOverhead + len(sibling.Value) + sibling.Clock.encodedSize()
+	}
+	return size
+}
+
+// Returns the bytes req takes at most when gob encoded
+func (req *WriteRequest) encodedSize() int {
+	return encodingOverhead + len(req.Key) + len(req.Value) +
+		req.Context.encodedSize() + req.Erasure.encodedSize()
+}
+
+// Returns the bytes the entries of vc take at most when gob encoded
+func (vc VectorClock) encodedSize() int {
+	size := 0
+	for address := range vc {
+		size += len(address) + 18
+	}
+	return size
+}
+
+// Returns the bytes erasure takes at most when
+// gob encoded, 0 if it is nil
+func (erasure *Erasure) encodedSize() int {
+	if erasure == nil {
+		return 0
+	}
+	size := encodingOverhead + len(erasure.Id)
+	for i := range erasure.Holders {
+		size += len(erasure.Holders[i]) + 9
+	}
+	for i := range erasure.Hashes {
+		size += len(erasure.Hashes[i]) + 9
+	}
+	return size
+}
+
+// Returns the bytes fragment takes at most when gob encoded
+func (fragment Fragment) size() int {
+	return encodingOverhead + len(fragment.Key) + len(fragment.Id) + len(fragment.Data)
+}
+
+// Limits are the sizes in bytes of the Keys and Values a
+// node accepts, see Config.MaxKeySize and Config.MaxValueSize.
+// 0 for no limit.
+type Limits struct {
+	MaxKeySize   int
+	MaxValueSize int
+}
+
+// Check if the Key and Value of req are within limits
+func (limits Limits) check(req *WriteRequest) error {
+	if max := limits.MaxKeySize; max > 0 && len(req.Key) > max {
+		return ErrKeyTooLarge
+	}
+	if max := limits.MaxValueSize; max > 0 && !req.Delete && req.size() > max {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// Returns the limits of the node on Keys and Values
+func (node *Node) limits() Limits {
+	return Limits{node.config.MaxKeySize, node.config.MaxValueSize}
+}
+
+// Check if the Key and Value of req are within
+// Config.MaxKeySize and Config.MaxValueSize
+func (node *Node) checkSize(req *WriteRequest) error {
+	return node.limits().check(req)
+}
+
+// Replies with the limits of the node on Keys and Values,
+// which clients check writes against before sending them
+func (node *RPCNode) GetLimits(_ *string, limits *Limits) error {
+	*limits = node.limits()
+	return nil
+}
+
+// Bytes a message may take beyond the Key and Value it
+// carries, for the rpc header and the other arguments
+const messageOverhead = 64 << 10
+
+// Bytes of writes or Keys a client sends to a node in one
+// batch at most, a larger write is sent alone. Nodes read
+// messages of at least messageOverhead bytes beyond a Key
+// and Value whatever their config.
+const clientBatchSize = messageOverhead / 2
+
+// Returns the size in bytes of the largest message the node
+// reads, see messageReader. Writes are bound by Config.MaxKeySize
+// and Config.MaxValueSize, and batches of Items sent between
+// nodes by Config.TransferChunkSize.
+func (node *Node) messageLimit() int {
+	limit := node.config.MaxKeySize + node.config.MaxValueSize
+	if node.config.TransferChunkSize > limit {
+		limit = node.config.TransferChunkSize
+	}
+	return limit + messageOverhead
+}
+
+// Returns the bytes held by the node, the Keys and Values
+// of its store and the fragments it holds. The node's lock
+// must be held.
+func (node *Node) storedBytes() int {
+	return node.store.bytes + node.fragmentBytes
+}
+
+// Check if the node can hold growth more bytes
+// within Config.Capacity. The node's lock must be held.
+func (node *Node) checkCapacity(growth int) error {
+	capacity := node.config.Capacity
+	if capacity <= 0 || growth <= 0 {
+		return nil
+	}
+	if node.storedBytes()+growth > capacity {
+		node.logger.Warn("rejected write over capacity", "capacity", capacity)
+		return ErrCapacityExceeded
+	}
+	return nil
+}
+
+// Returns the bytes applying req adds to the store.
+// The node's lock must be held.
+func (node *Node) growth(req *WriteRequest) int {
+	if req.Delete {
+		return 0
+	}
+	growth := len(req.Value)
+	current, ok := node.store.stored(req.Key)
+	if !ok {
+		growth += len(req.Key)
+	} else if !node.config.VectorClocks {
+		// the Value replaces the current one, whereas
+		// siblings may be kept along with it
+		growth -= current.footprint()
+	}
+	return growth
+}
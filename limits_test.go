@@ -0,0 +1,148 @@
+package chord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSize(t *testing.T) {
+	node := newTestNode()
+	node.config.MaxKeySize = 8
+	node.config.MaxValueSize = 16
+
+	cases := []struct {
+		req *WriteRequest
+		err error
+	}{
+		{&WriteRequest{Key: "key", Value: make([]byte, 16)}, nil},
+		{&WriteRequest{Key: "long key", Value: []byte("value")}, nil},
+		{&WriteRequest{Key: "longer key", Value: []byte("value")}, ErrKeyTooLarge},
+		{&WriteRequest{Key: "key", Value: make([]byte, 17)}, ErrValueTooLarge},
+		{&WriteRequest{Key: "key", Erasure: &Erasure{Size: 17}}, ErrValueTooLarge},
+		{&WriteRequest{Key: "key", Delete: true}, nil},
+	}
+	for _, c := range cases {
+		if err := node.checkSize(c.req); err != c.err {
+			t.Errorf("key %q of %d bytes: %v", c.req.Key, c.req.size(), err)
+		}
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	node := newTestNode()
+	node.config.Capacity = 20
+	node.store.set("key", []byte(strings.Repeat("v", 10)), time.Time{})
+
+	if err := node.checkCapacity(node.growth(&WriteRequest{Key: "other", Value: make([]byte, 2)})); err != nil {
+		t.Errorf("write within capacity: %v", err)
+	}
+	if err := node.checkCapacity(node.growth(&WriteRequest{Key: "other", Value: make([]byte, 3)})); err != ErrCapacityExceeded {
+		t.Errorf("write over capacity: %v", err)
+	}
+
+	// replacing a Value grows the store by the difference
+	if err := node.checkCapacity(node.growth(&WriteRequest{Key: "key", Value: make([]byte, 17)})); err != nil {
+		t.Errorf("rewrite within capacity: %v", err)
+	}
+	if err := node.checkCapacity(node.growth(&WriteRequest{Key: "key", Delete: true})); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+}
+
+func TestMessageReaderCapsMessages(t *testing.T) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	enc.Encode(KeyValue{"small", make([]byte, 100)})
+	enc.Encode(KeyValue{"large", make([]byte, 10000)})
+	enc.Encode(KeyValue{"after", make([]byte, 100)})
+
+	dec := gob.NewDecoder(&messageReader{r: bufio.NewReader(&buf), max: 1000})
+	var kv KeyValue
+	if err := dec.Decode(&kv); err != nil || kv.Key != "small" {
+		t.Fatalf("decoded %q, %v", kv.Key, err)
+	}
+	if err := dec.Decode(&kv); err != ErrMessageTooLarge {
+		t.Fatalf("decoded message over the limit: %v", err)
+	}
+
+	// the large message is skipped, not the stream
+	if err := dec.Decode(&kv); err != nil || kv.Key != "after" {
+		t.Fatalf("decoded %q after the large message, %v", kv.Key, err)
+	}
+}
+
+func TestStoredBytesFollowChanges(t *testing.T) {
+	node := newTestNode()
+	node.store.set("key", []byte("value"), time.Time{})
+	node.store.set("key", []byte("longer value"), time.Time{})
+	node.putFragment(fragmentId{"coded", "id", 0}, storedFragment{data: make([]byte, 10)})
+	if bytes := node.storedBytes(); bytes != len("key")+len("longer value")+10 {
+		t.Errorf("stored bytes = %d", bytes)
+	}
+
+	node.store.del([]string{"key"})
+	node.dropFragment(fragmentId{"coded", "id", 0})
+	if bytes := node.storedBytes(); bytes != 0 {
+		t.Errorf("stored bytes = %d once everything is deleted", bytes)
+	}
+}
+
+func TestBatchesFitMessageLimit(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := startRing(t, ca, 2, func(config *Config) {
+		config.MaxValueSize = 1024
+		config.TransferChunkSize = 16 << 10
+	})
+	defer r.stop()
+	client := r.client(t)
+	defer client.Close()
+
+	// far more than a single message of the nodes may hold
+	pairs := make([]KeyValue, 200)
+	for i := range pairs {
+		pairs[i] = KeyValue{fmt.Sprint("batch-", i), make([]byte, 1000)}
+	}
+	results, err := client.MultiPut(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Err != "" {
+			t.Fatalf("put %s: %s", result.Key, result.Err)
+		}
+	}
+
+	// a message over the limit fails its call
+	// and the connection is kept
+	var node string
+	if err = client.call("RPCNode.Save", KeyValue{"large", make([]byte, 200<<10)}, &node); err != ErrMessageTooLarge {
+		t.Errorf("sent message over the limit: %v", err)
+	}
+	if _, err = client.Put("after", []byte("value")); err != nil {
+		t.Errorf("put after a message over the limit: %v", err)
+	}
+
+	// clients check writes against the
+	// limits they read from the node
+	if client.limits.MaxValueSize != 1024 {
+		t.Errorf("client read limits %+v", client.limits)
+	}
+	if _, err = client.Put("large", make([]byte, 2000)); err != ErrValueTooLarge {
+		t.Errorf("put over the limit: %v", err)
+	}
+	results, err = client.MultiPut([]KeyValue{{"small", []byte("value")}, {"large", make([]byte, 2000)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != "" || results[1].Err != ErrValueTooLarge.Error() || results[1].Key != "large" {
+		t.Errorf("multi put over the limit: %+v", results)
+	}
+}
@@ -0,0 +1,136 @@
+package chord
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is used by a node to log its activity. The
+// method set matches that of *slog.Logger, so a slog
+// logger can be passed in Config directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level is the severity of a log message. Values
+// are the same as those of slog.Level
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// textLogger writes one line per message with
+// args formatted as key=value pairs
+type textLogger struct {
+	mutex sync.Mutex
+	out   io.Writer
+	level Level
+}
+
+// NewLogger returns a Logger writing messages of
+// given level and above to w
+func NewLogger(w io.Writer, level Level) Logger {
+	return &textLogger{out: w, level: level}
+}
+
+func (l *textLogger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *textLogger) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *textLogger) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *textLogger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *textLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(time.Now().Format(time.RFC3339))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(quote(msg))
+	for i := 0; i < len(args); i += 2 {
+		// a trailing key without value is
+		// logged the same way slog does
+		if i+1 == len(args) {
+			b.WriteString(" !BADKEY=")
+			b.WriteString(quote(fmt.Sprint(args[i])))
+			break
+		}
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprint(args[i]))
+		b.WriteString("=")
+		b.WriteString(quote(fmt.Sprint(args[i+1])))
+	}
+	b.WriteString("\n")
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	io.WriteString(l.out, b.String())
+}
+
+// Quote the string if it can not be
+// read back as a single value
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// nodeLogger adds the address and id of
+// a node to every message
+type nodeLogger struct {
+	logger Logger
+	fields []interface{}
+}
+
+func newNodeLogger(logger Logger, address string, id []byte) *nodeLogger {
+	return &nodeLogger{
+		logger: logger,
+		fields: []interface{}{"node", address, "id", toBigInt(id).String()},
+	}
+}
+
+func (l *nodeLogger) with(args []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(l.fields)+len(args)), l.fields...), args...)
+}
+
+func (l *nodeLogger) Debug(msg string, args ...interface{}) { l.logger.Debug(msg, l.with(args)...) }
+func (l *nodeLogger) Info(msg string, args ...interface{})  { l.logger.Info(msg, l.with(args)...) }
+func (l *nodeLogger) Warn(msg string, args ...interface{})  { l.logger.Warn(msg, l.with(args)...) }
+func (l *nodeLogger) Error(msg string, args ...interface{}) { l.logger.Error(msg, l.with(args)...) }
+
+// Returns what should be logged in place of a value.
+// Values are redacted unless Config.LogValues is set
+func (node *Node) redact(value []byte) string {
+	if node.config.LogValues {
+		return string(value)
+	}
+	return fmt.Sprintf("[redacted %d bytes]", len(value))
+}
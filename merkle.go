@@ -0,0 +1,291 @@
+package chord
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"time"
+)
+
+// Depth of the merkle trees compared during anti-entropy.
+// The leaves split the ring into 2^merkleDepth buckets by
+// the leading bits of the hash of keys.
+const merkleDepth = 10
+
+// merkleTree holds the hashes of a merkle tree over
+// the Items of a key range. levels[0] holds the root
+// and levels[merkleDepth] the leaves, the children of
+// node i of a level are nodes 2i and 2i+1 of the next.
+type merkleTree struct {
+	levels [][][]byte
+}
+
+// merkleLeaves holds the hash of every stored Item by the
+// leaf its Key falls in, along with the XOR of the hashes
+// of each leaf. It is updated on every change to the store
+// so that trees are built without reading the store.
+type merkleLeaves struct {
+	hashes []map[string][]byte
+	xors   [][]byte
+}
+
+func newMerkleLeaves() *merkleLeaves {
+	leaves := &merkleLeaves{
+		hashes: make([]map[string][]byte, 1<<merkleDepth),
+		xors:   make([][]byte, 1<<merkleDepth),
+	}
+	for i := range leaves.hashes {
+		leaves.hashes[i] = make(map[string][]byte)
+		leaves.xors[i] = make([]byte, sha1.Size)
+	}
+	return leaves
+}
+
+func xorInto(dst, src []byte) {
+	for i, b := range src {
+		dst[i] ^= b
+	}
+}
+
+// Sets the hash of the Item stored under key
+func (leaves *merkleLeaves) set(key string, item Item) {
+	leaves.remove(key)
+
+	h := sha1.New()
+	writeItem(h, TransferItem{key, item})
+	hash := h.Sum(nil)
+
+	leaf := leafIndex(getHash(key))
+	leaves.hashes[leaf][key] = hash
+	xorInto(leaves.xors[leaf], hash)
+}
+
+// Removes the hash of the Item stored under key
+func (leaves *merkleLeaves) remove(key string) {
+	leaf := leafIndex(getHash(key))
+	if hash, ok := leaves.hashes[leaf][key]; ok {
+		xorInto(leaves.xors[leaf], hash)
+		delete(leaves.hashes[leaf], key)
+	}
+}
+
+// Returns the hash of leaf over the Items in (start, end].
+// Only the leaves holding start or end are split by the
+// range, the others lie entirely within it or outside it.
+func (leaves *merkleLeaves) leaf(i int, start, end []byte) []byte {
+	if leafIndex(start) == i || leafIndex(end) == i {
+		xor := make([]byte, sha1.Size)
+		for key, hash := range leaves.hashes[i] {
+			if betweenRightInc(getHash(key), start, end) {
+				xorInto(xor, hash)
+			}
+		}
+		return xor
+	}
+	for key := range leaves.hashes[i] {
+		if betweenRightInc(getHash(key), start, end) {
+			// a copy, the tree is hashed and sent once
+			// the mutex of the node is released
+			return append([]byte{}, leaves.xors[i]...)
+		}
+		break
+	}
+	return make([]byte, sha1.Size)
+}
+
+// MerkleRequest asks for nodes of the merkle
+// tree over the key range (Start, End]
+type MerkleRequest struct {
+	Start ID
+	End   ID
+
+	// level of the tree and the indices
+	// of the nodes in that level
+	Level   int
+	Indices []int
+}
+
+// Returns the index of the leaf in which
+// the key with given hash falls
+func leafIndex(hash []byte) int {
+	return int(binary.BigEndian.Uint16(hash) >> (16 - merkleDepth))
+}
+
+// Returns the merkle tree over the Items in (start, end].
+// A leaf hash is the XOR of the hashes of its Items so it
+// does not depend on the order in which Items were stored.
+// Expired Items count until they are removed by expire.
+func (node *Node) merkleTree(start, end []byte) *merkleTree {
+	leaves := make([][]byte, 1<<merkleDepth)
+	node.mutex.RLock()
+	for i := range leaves {
+		leaves[i] = node.store.leaves.leaf(i, start, end)
+	}
+	node.mutex.RUnlock()
+
+	tree := &merkleTree{levels: make([][][]byte, merkleDepth+1)}
+	tree.levels[merkleDepth] = leaves
+	for level := merkleDepth - 1; level >= 0; level-- {
+		children := tree.levels[level+1]
+		hashes := make([][]byte, len(children)/2)
+		for i := range hashes {
+			h := sha1.New()
+			h.Write(children[2*i])
+			h.Write(children[2*i+1])
+			hashes[i] = h.Sum(nil)
+		}
+		tree.levels[level] = hashes
+	}
+	return tree
+}
+
+// Returns the Items in (start, end] which have not
+// expired and fall in one of the given leaves
+func (node *Node) leafItems(start, end []byte, leaves []int) []TransferItem {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	now := time.Now()
+	items := make([]TransferItem, 0)
+	for _, leaf := range leaves {
+		if leaf < 0 || leaf >= len(node.store.leaves.hashes) {
+			continue
+		}
+		for key := range node.store.leaves.hashes[leaf] {
+			item, _ := node.store.stored(key)
+			if item.expired(now) || !betweenRightInc(getHash(key), start, end) {
+				continue
+			}
+			items = append(items, TransferItem{key, item})
+		}
+	}
+	return items
+}
+
+// Compares the keys owned by this node with each
+// of its replicas and repairs the differences
+func (node *Node) antiEntropy() {
+	replicas := node.replicaTargets()
+
+	node.mutex.RLock()
+	start, end := node.predecessorId, node.id
+	node.mutex.RUnlock()
+
+	if start == nil || len(replicas) == 0 {
+		return
+	}
+
+	node.metrics.antiEntropyRounds.inc()
+	for _, replica := range replicas {
+		if err := node.syncReplica(replica, start, end); err != nil {
+			node.logger.Warn("anti-entropy failed", "replica", replica, "err", err)
+		}
+	}
+}
+
+// Walks the merkle trees over (start, end] of this node and
+// the replica from the root down to the leaves, descending only
+// into subtrees whose hashes differ. Items of the differing
+// leaves are then exchanged, the newer copy of each Item wins.
+func (node *Node) syncReplica(replica string, start, end []byte) error {
+	replicaRPC, err := node.getClient(replica)
+	if err != nil {
+		return err
+	}
+	defer replicaRPC.Close()
+
+	local := node.merkleTree(start, end)
+
+	var leaves []int
+	indices := []int{0}
+	for level := 0; len(indices) > 0; level++ {
+		req := &MerkleRequest{start, end, level, indices}
+		var remote [][]byte
+		if err = replicaRPC.Call("RPCNode.MerkleHashes", req, &remote); err != nil {
+			return err
+		}
+
+		differing := make([]int, 0)
+		for j, i := range indices {
+			if !equal(local.levels[level][i], remote[j]) {
+				differing = append(differing, i)
+			}
+		}
+
+		if level == merkleDepth {
+			leaves = differing
+			break
+		}
+		indices = make([]int, 0, 2*len(differing))
+		for _, i := range differing {
+			indices = append(indices, 2*i, 2*i+1)
+		}
+	}
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	req := &MerkleRequest{start, end, merkleDepth, leaves}
+	var remoteItems []TransferItem
+	if err = replicaRPC.Call("RPCNode.MerkleLeaves", req, &remoteItems); err != nil {
+		return err
+	}
+	localItems := node.leafItems(start, end, leaves)
+
+	// pull Items which are newer on the replica
+	remote := make(map[string]Item, len(remoteItems))
+	pulled := 0
+	node.mutex.Lock()
+	for _, ti := range remoteItems {
+		remote[ti.Key] = ti.Item
+		if node.store.merge(ti.Key, ti.Item) {
+			pulled++
+		}
+	}
+	node.mutex.Unlock()
+
+	// push Items which are newer here
+	push := make([]TransferItem, 0)
+	for _, ti := range localItems {
+		if item, ok := remote[ti.Key]; !ok || newer(ti.Item, item) {
+			push = append(push, ti)
+		}
+	}
+	if err = node.replicateTo(replicaRPC, push); err != nil {
+		return err
+	}
+
+	node.metrics.keysRepairedPulled.add(pulled)
+	node.metrics.keysRepairedPushed.add(len(push))
+	node.logger.Debug("repaired replica", "replica", replica, "leaves", len(leaves), "pushed", len(push), "pulled", pulled)
+	return nil
+}
+
+// Replies with the hashes of the requested nodes of
+// the merkle tree over the Items in (Start, End]
+func (node *RPCNode) MerkleHashes(req *MerkleRequest, hashes *[][]byte) error {
+	if req.Level < 0 || req.Level > merkleDepth {
+		return ErrInvalidMerkleRequest
+	}
+
+	tree := node.merkleTree(req.Start, req.End)
+	level := tree.levels[req.Level]
+
+	*hashes = make([][]byte, len(req.Indices))
+	for j, i := range req.Indices {
+		if i < 0 || i >= len(level) {
+			return ErrInvalidMerkleRequest
+		}
+		(*hashes)[j] = level[i]
+	}
+	return nil
+}
+
+// Replies with the Items in (Start, End] which fall
+// in the requested leaves, tombstones included
+func (node *RPCNode) MerkleLeaves(req *MerkleRequest, items *[]TransferItem) error {
+	if req.Level != merkleDepth {
+		return ErrInvalidMerkleRequest
+	}
+	*items = node.leafItems(req.Start, req.End, req.Indices)
+	return nil
+}
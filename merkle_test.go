@@ -0,0 +1,74 @@
+package chord
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Returns the root of the merkle tree of node over the whole ring
+func merkleRoot(node *RPCNode) []byte {
+	return node.merkleTree(node.id, node.id).levels[0][0]
+}
+
+func TestMerkleTreeFollowsStore(t *testing.T) {
+	a, b := newTestNode(), newTestNode()
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key-", i)
+		a.store.put(key, Item{Value: []byte(key), Version: 1})
+		key = fmt.Sprint("key-", 99-i)
+		b.store.put(key, Item{Value: []byte(key), Version: 1})
+	}
+	if !bytes.Equal(merkleRoot(a), merkleRoot(b)) {
+		t.Fatal("trees over the same Items differ")
+	}
+
+	b.store.set("key-7", []byte("changed"), time.Time{})
+	if bytes.Equal(merkleRoot(a), merkleRoot(b)) {
+		t.Fatal("tree does not follow a write")
+	}
+	b.store.put("key-7", Item{Value: []byte("key-7"), Version: 1})
+	b.store.set("extra", []byte("extra"), time.Time{})
+	b.store.del([]string{"extra"})
+	if !bytes.Equal(merkleRoot(a), merkleRoot(b)) {
+		t.Error("tree does not follow a delete")
+	}
+}
+
+func TestMerkleHashesDuringWrites(t *testing.T) {
+	node := newTestNode()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			key := fmt.Sprint("key-", i%100)
+			node.mutex.Lock()
+			node.store.set(key, []byte(fmt.Sprint(i)), time.Time{})
+			node.mutex.Unlock()
+		}
+	}()
+
+	// the hashes are read, as when sent to a replica,
+	// while the writes go on
+	indices := make([]int, 1<<merkleDepth)
+	for i := range indices {
+		indices[i] = i
+	}
+	req := &MerkleRequest{node.id, node.id, merkleDepth, indices}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		var hashes [][]byte
+		if err := node.MerkleHashes(req, &hashes); err != nil {
+			t.Fatal(err)
+		}
+		for _, hash := range hashes {
+			sha1.Sum(hash)
+		}
+	}
+}
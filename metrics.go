@@ -0,0 +1,262 @@
+package chord
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bucket upper bounds used for the hops per lookup histogram
+var hopBuckets = []float64{0, 1, 2, 3, 4, 5, 6, 8, 10, 15, 20}
+
+// Bucket upper bounds (in seconds) used for the rpc
+// latency histograms
+var latencyBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025,
+	0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+}
+
+// counter is a monotonically increasing value
+// which is safe for concurrent use.
+type counter struct {
+	value uint64
+}
+
+// Increment counter by one
+func (c *counter) inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Increment counter by n
+func (c *counter) add(n int) {
+	atomic.AddUint64(&c.value, uint64(n))
+}
+
+func (c *counter) get() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// histogram counts observations into cumulative
+// buckets as per the prometheus histogram type.
+type histogram struct {
+	mutex   sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+}
+
+// Record a single observation
+func (h *histogram) observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Write histogram samples in text exposition format
+// labels are added to every sample and must already
+// be formatted i.e. `method="RPCNode.GetId",`
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, labels, formatFloat(bound), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// metrics holds the instrumentation of a single node
+type metrics struct {
+	lookups    counter
+	lookupHops *histogram
+
+	stabilizeRounds   counter
+	stabilizeFailures counter
+	fixFingerRounds   counter
+	fixFingerFailures counter
+	predecessorResets counter
+
+	keysTransferredIn  counter
+	keysTransferredOut counter
+	keysExpired        counter
+
+	antiEntropyRounds  counter
+	keysRepairedPushed counter
+	keysRepairedPulled counter
+	readRepairs        counter
+	readRepairFailures counter
+	fragmentsRepaired  counter
+
+	hintsStored   counter
+	hintsReplayed counter
+	hintsDropped  counter
+
+	identityRejections counter
+	secureLookups      counter
+	suspiciousLookups  counter
+
+	// rpc latency histograms and requests rejected
+	// over limits keyed by service method name
+	rpcMutex     sync.Mutex
+	rpcLatency   map[string]*histogram
+	rpcRejection map[string]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		lookupHops:   newHistogram(hopBuckets),
+		rpcLatency:   make(map[string]*histogram),
+		rpcRejection: make(map[string]uint64),
+	}
+}
+
+// Record the time taken to serve an rpc
+func (m *metrics) observeRPC(method string, elapsed time.Duration) {
+	m.rpcMutex.Lock()
+	h, ok := m.rpcLatency[method]
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		m.rpcLatency[method] = h
+	}
+	m.rpcMutex.Unlock()
+	h.observe(elapsed.Seconds())
+}
+
+// Record a request rejected over the limits of the node
+func (m *metrics) observeRejection(method string) {
+	m.rpcMutex.Lock()
+	m.rpcRejection[method]++
+	m.rpcMutex.Unlock()
+}
+
+// Record a completed lookup and the number
+// of hops it took
+func (m *metrics) observeLookup(hops int) {
+	m.lookups.inc()
+	m.lookupHops.observe(float64(hops))
+}
+
+// Write all metrics in prometheus text exposition format.
+// keys and bytes are the current size of the node's store,
+// hints the number of hints it holds.
+func (m *metrics) write(w io.Writer, keys, bytes, hints int) {
+	writeCounter(w, "chord_lookups_total", "Lookups started by this node.", m.lookups.get())
+
+	writeHeader(w, "chord_lookup_hops", "Number of hops taken per lookup.", "histogram")
+	m.lookupHops.write(w, "chord_lookup_hops", "")
+
+	writeHeader(w, "chord_rpc_duration_seconds", "Time taken to serve rpc requests by method.", "histogram")
+	m.rpcMutex.Lock()
+	methods := make([]string, 0, len(m.rpcLatency))
+	for method := range m.rpcLatency {
+		methods = append(methods, method)
+	}
+	m.rpcMutex.Unlock()
+	sort.Strings(methods)
+	for _, method := range methods {
+		m.rpcMutex.Lock()
+		h := m.rpcLatency[method]
+		m.rpcMutex.Unlock()
+		h.write(w, "chord_rpc_duration_seconds", fmt.Sprintf("method=%q,", method))
+	}
+
+	writeHeader(w, "chord_rpc_rejected_total", "Requests rejected over the rate limits of the node by method.", "counter")
+	m.rpcMutex.Lock()
+	methods = methods[:0]
+	for method := range m.rpcRejection {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		fmt.Fprintf(w, "chord_rpc_rejected_total{method=%q} %d\n", method, m.rpcRejection[method])
+	}
+	m.rpcMutex.Unlock()
+
+	writeCounter(w, "chord_stabilize_rounds_total", "Stabilize rounds run.", m.stabilizeRounds.get())
+	writeCounter(w, "chord_stabilize_failures_total", "Stabilize rounds which failed to reach the successor.", m.stabilizeFailures.get())
+	writeCounter(w, "chord_fix_finger_rounds_total", "Fix finger rounds run.", m.fixFingerRounds.get())
+	writeCounter(w, "chord_fix_finger_failures_total", "Fix finger rounds which failed to reach the finger.", m.fixFingerFailures.get())
+	writeCounter(w, "chord_predecessor_resets_total", "Times the predecessor was reset after failing.", m.predecessorResets.get())
+
+	writeHeader(w, "chord_keys_stored", "Keys currently stored on this node.", "gauge")
+	fmt.Fprintf(w, "chord_keys_stored %d\n", keys)
+	writeHeader(w, "chord_bytes_stored", "Bytes of keys, values and fragments held by this node.", "gauge")
+	fmt.Fprintf(w, "chord_bytes_stored %d\n", bytes)
+
+	writeHeader(w, "chord_keys_transferred_total", "Keys transferred between nodes.", "counter")
+	fmt.Fprintf(w, "chord_keys_transferred_total{direction=\"in\"} %d\n", m.keysTransferredIn.get())
+	fmt.Fprintf(w, "chord_keys_transferred_total{direction=\"out\"} %d\n", m.keysTransferredOut.get())
+
+	writeCounter(w, "chord_keys_expired_total", "Keys removed after their TTL passed.", m.keysExpired.get())
+
+	writeCounter(w, "chord_anti_entropy_rounds_total", "Anti-entropy rounds run against replicas.", m.antiEntropyRounds.get())
+	writeHeader(w, "chord_keys_repaired_total", "Keys repaired by anti-entropy.", "counter")
+	fmt.Fprintf(w, "chord_keys_repaired_total{direction=\"pushed\"} %d\n", m.keysRepairedPushed.get())
+	fmt.Fprintf(w, "chord_keys_repaired_total{direction=\"pulled\"} %d\n", m.keysRepairedPulled.get())
+	writeCounter(w, "chord_read_repairs_total", "Stale replicas repaired by reads.", m.readRepairs.get())
+	writeCounter(w, "chord_read_repair_failures_total", "Stale replicas which reads failed to repair.", m.readRepairFailures.get())
+	writeCounter(w, "chord_fragments_repaired_total", "Missing fragments of erasure coded values stored again.", m.fragmentsRepaired.get())
+
+	writeHeader(w, "chord_hints_pending", "Hints held for unreachable nodes.", "gauge")
+	fmt.Fprintf(w, "chord_hints_pending %d\n", hints)
+	writeCounter(w, "chord_hints_stored_total", "Writes stored as hints for unreachable nodes.", m.hintsStored.get())
+	writeCounter(w, "chord_hints_replayed_total", "Hints replayed to the nodes they were held for.", m.hintsReplayed.get())
+	writeCounter(w, "chord_hints_dropped_total", "Hints dropped as too many were held or they were too old.", m.hintsDropped.get())
+
+	writeCounter(w, "chord_identity_rejections_total", "Nodes rejected as neighbours for an invalid identity.", m.identityRejections.get())
+	writeCounter(w, "chord_secure_lookups_total", "Lookups resolved by redundant lookups.", m.secureLookups.get())
+	writeCounter(w, "chord_suspicious_lookups_total", "Redundant lookups whose answers disagreed.", m.suspiciousLookups.get())
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeCounter(w io.Writer, name, help string, value uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Serves the node's metrics on /metrics
+func (node *Node) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	node.mutex.RLock()
+	keys := node.store.live()
+	bytes := node.storedBytes()
+	hints := node.hintCount
+	node.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	node.metrics.write(w, keys, bytes, hints)
+}
@@ -0,0 +1,272 @@
+package chord
+
+import (
+	"strings"
+)
+
+// Separates the namespace from the key within the Key
+// a namespaced key is stored under
+const namespaceSeparator = "\x00"
+
+// Access is a right clients are granted on a namespace
+type Access int
+
+const (
+	AccessRead Access = iota
+	AccessWrite
+	AccessAdmin
+)
+
+// Namespace holds the access control and the quotas of a
+// namespace, see Config.Namespaces
+type Namespace struct {
+	// common names of the client certificates granted each
+	// access, "*" grants it to every client. Admins may also
+	// read and write, and query the usage of the namespace.
+	Readers []string
+	Writers []string
+	Admins  []string
+
+	// maximum number of keys, and of bytes of their Values,
+	// of the namespace each node owns. 0 for no limit.
+	MaxKeys  int
+	MaxBytes int
+}
+
+// Usage is what a namespace stores on a node
+type Usage struct {
+	Keys  int
+	Bytes int
+}
+
+// NamespacedKey returns the Key under which key of namespace
+// is stored. The namespace is hashed along with the key, so
+// that the same key in two namespaces is stored apart.
+func NamespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + namespaceSeparator + key
+}
+
+// Splits a stored Key into its namespace and key. Keys
+// which are not namespaced are in the namespace "".
+func splitNamespace(key string) (string, string) {
+	if i := strings.Index(key, namespaceSeparator); i >= 0 {
+		return key[:i], key[i+len(namespaceSeparator):]
+	}
+	return "", key
+}
+
+// Check if principal is listed, or every client is
+func listed(principals []string, principal string) bool {
+	for _, p := range principals {
+		if p == "*" || (p == principal && principal != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if principal has access to the namespace
+func (ns Namespace) allows(principal string, access Access) bool {
+	if listed(ns.Admins, principal) {
+		return true
+	}
+	switch access {
+	case AccessRead:
+		return listed(ns.Readers, principal)
+	case AccessWrite:
+		return listed(ns.Writers, principal)
+	}
+	return false
+}
+
+// Check if principal may access the namespace of key.
+// Namespaces missing from Config.Namespaces are open.
+func (node *Node) checkAccess(principal, key string, access Access) error {
+	namespace, _ := splitNamespace(key)
+	ns, ok := node.config.Namespaces[namespace]
+	if !ok || ns.allows(principal, access) {
+		return nil
+	}
+	node.logger.Warn("denied access to namespace", "namespace", namespace, "client", principal)
+	return ErrAccessDenied
+}
+
+// Checks the access of the client to the keys in the arguments of
+// a client method, body of which has been decoded. Members of the
+// ring have access to every namespace.
+func (c *serverCodec) checkNamespaces(body interface{}) error {
+	if c.member || len(c.node.config.Namespaces) == 0 {
+		return nil
+	}
+
+	check := func(key string, access Access) error {
+		return c.node.checkAccess(c.principal, key, access)
+	}
+	switch c.method {
+	case "RPCNode.Retrieve", "RPCNode.RetrieveItem":
+		return check(*body.(*string), AccessRead)
+	case "RPCNode.Delete":
+		return check(*body.(*string), AccessWrite)
+	case "RPCNode.Read":
+		return check(body.(*ReadRequest).Key, AccessRead)
+	case "RPCNode.Write":
+		return check(body.(*WriteRequest).Key, AccessWrite)
+	case "RPCNode.Save":
+		return check(body.(*KeyValue).Key, AccessWrite)
+	case "RPCNode.MultiGet":
+		for _, key := range *body.(*[]string) {
+			if err := check(key, AccessRead); err != nil {
+				return err
+			}
+		}
+	case "RPCNode.MultiWrite":
+		for _, req := range *body.(*[]WriteRequest) {
+			if err := check(req.Key, AccessWrite); err != nil {
+				return err
+			}
+		}
+	case "RPCNode.NamespaceUsage":
+		return check(NamespacedKey(*body.(*string), ""), AccessAdmin)
+	case "RPCNode.GetKeys":
+		// keys of every namespace are listed
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// Returns the size of the Value of item
+func (item Item) size() int {
+	if item.Erasure != nil {
+		return item.Erasure.Size
+	}
+	if len(item.Siblings) == 0 {
+		return len(item.Value)
+	}
+	size := 0
+	for _, sibling := range item.Siblings {
+		size += len(sibling.Value)
+	}
+	return size
+}
+
+// Returns the usage an Item adds to its namespace,
+// tombstones and missing Items add none
+func usageOf(item *Item) Usage {
+	if item == nil || item.Deleted {
+		return Usage{}
+	}
+	return Usage{1, item.size()}
+}
+
+// Updates the usage of the namespace of key as its Item
+// changes from old to item, either of which may be nil.
+// Called by the store, the node's lock must be held.
+func (node *Node) countUsage(key string, old, item *Item) {
+	if node.predecessorId != nil && !betweenRightInc(getHash(key), node.predecessorId, node.id) {
+		return
+	}
+	namespace, _ := splitNamespace(key)
+	before, after := usageOf(old), usageOf(item)
+
+	usage := node.usages[namespace]
+	usage.Keys += after.Keys - before.Keys
+	usage.Bytes += after.Bytes - before.Bytes
+	if usage.Keys == 0 {
+		delete(node.usages, namespace)
+		return
+	}
+	node.usages[namespace] = usage
+}
+
+// Counts the usage of every namespace again, once the
+// range of keys the node owns has changed. The node's
+// lock must be held.
+func (node *Node) recountUsage() {
+	node.usages = make(map[string]Usage)
+	node.store.each(func(key string, item Item) {
+		node.countUsage(key, nil, &item)
+	})
+}
+
+// Returns the usage of namespace among the keys the node
+// owns. Expired keys count until they are removed by the
+// expiry sweep. The node's lock must be held.
+func (node *Node) usage(namespace string) Usage {
+	return node.usages[namespace]
+}
+
+// Check if applying req keeps the namespace of its Key within
+// its quota. Writes which do not grow the namespace always are.
+// The node's lock must be held.
+func (node *Node) checkQuota(req *WriteRequest) error {
+	namespace, _ := splitNamespace(req.Key)
+	ns, ok := node.config.Namespaces[namespace]
+	if req.Delete || !ok || (ns.MaxKeys <= 0 && ns.MaxBytes <= 0) {
+		return nil
+	}
+
+	// the usage of the Item replaced, expired or not
+	// yet, is counted until the write replaces it
+	var current Usage
+	if item, ok := node.store.stored(req.Key); ok {
+		current = usageOf(&item)
+	}
+	size := req.size()
+	usage := node.usage(namespace)
+
+	if ns.MaxKeys > 0 && current.Keys == 0 && usage.Keys >= ns.MaxKeys {
+		return ErrQuotaExceeded
+	}
+	if ns.MaxBytes > 0 && size > current.Bytes && usage.Bytes-current.Bytes+size > ns.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Returns the usage of the namespace among the keys the node owns
+func (node *RPCNode) NamespaceUsage(namespace *string, usage *Usage) error {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	*usage = node.usage(*namespace)
+	return nil
+}
+
+// Namespace returns a client which reads and writes the keys
+// of namespace. It shares the connection of c.
+func (c *Client) Namespace(namespace string) *Client {
+	namespaced := *c
+	namespaced.namespace = namespace
+	return &namespaced
+}
+
+// NamespaceUsage returns the usage of the namespace on the
+// node the client is connected to. Clients need admin access.
+func (c *Client) NamespaceUsage(namespace string) (*Usage, error) {
+	usage := new(Usage)
+	if err := c.call("RPCNode.NamespaceUsage", &namespace, usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// Returns the Key key is stored under, within the namespace
+// of the client and hashed if it encrypts Values
+func (c *Client) key(key string) string {
+	if c.sealer != nil {
+		key = c.sealer.name(key)
+	}
+	return NamespacedKey(c.namespace, key)
+}
+
+// Sets the keys of results, which are in the order of
+// keys, back to the keys as passed to the client
+func (c *Client) unqualify(results []KeyResult, keys []string) {
+	for i := range results {
+		if i < len(keys) {
+			results[i].Key = keys[i]
+		}
+	}
+}
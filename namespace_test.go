@@ -0,0 +1,137 @@
+package chord
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNamespaceAllows(t *testing.T) {
+	ns := Namespace{Readers: []string{"*"}, Writers: []string{"app"}, Admins: []string{"ops"}}
+	cases := []struct {
+		principal string
+		access    Access
+		allowed   bool
+	}{
+		{"", AccessRead, true},
+		{"", AccessWrite, false},
+		{"app", AccessWrite, true},
+		{"app", AccessAdmin, false},
+		{"ops", AccessWrite, true},
+		{"ops", AccessAdmin, true},
+	}
+	for _, c := range cases {
+		if ns.allows(c.principal, c.access) != c.allowed {
+			t.Errorf("allows(%q, %d) = %v", c.principal, c.access, !c.allowed)
+		}
+	}
+}
+
+func TestNamespaceAccess(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+	billing := client.Namespace("billing")
+
+	if _, err := billing.Put("invoice", []byte("42")); err != ErrAccessDenied {
+		t.Errorf("write without access: %v", err)
+	}
+	if _, err := billing.Get("invoice"); err != ErrNoKeyValuePair {
+		t.Errorf("read with access: %v", err)
+	}
+	if _, err := billing.NamespaceUsage("billing"); err != ErrAccessDenied {
+		t.Errorf("usage without admin access: %v", err)
+	}
+
+	// namespaces without an entry are open
+	if _, err := client.Namespace("open").Put("invoice", []byte("42")); err != nil {
+		t.Errorf("write to open namespace: %v", err)
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+	quota := client.Namespace("quota")
+
+	// each owner takes a single key of the namespace
+	owners := make(map[string]bool)
+	stored := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint("key-", i)
+		lookup, err := client.Lookup(NamespacedKey("quota", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = quota.Put(key, []byte(key))
+		switch {
+		case err == nil:
+			stored[lookup.Address]++
+		case err == ErrQuotaExceeded:
+			if !owners[lookup.Address] {
+				t.Errorf("%s rejected by %s which holds no key", key, lookup.Address)
+			}
+		default:
+			t.Fatal(err)
+		}
+		owners[lookup.Address] = true
+	}
+
+	for address, keys := range stored {
+		if keys != 1 {
+			t.Errorf("%s stored %d keys", address, keys)
+		}
+		admin := r.clientOf(t, address)
+		usage, err := admin.NamespaceUsage("quota")
+		admin.Close()
+		if err != nil || usage.Keys != 1 {
+			t.Errorf("usage of %s = %+v, %v", address, usage, err)
+		}
+	}
+
+	// rewriting a key does not grow the namespace
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint("key-", i)
+		if _, err := quota.Get(key); err == nil {
+			if _, err = quota.Put(key, []byte("again")); err != nil {
+				t.Errorf("rewrite of %s: %v", key, err)
+			}
+		}
+	}
+}
+
+func TestUsageFollowsStore(t *testing.T) {
+	node := newTestNode()
+	node.store.set(NamespacedKey("team", "a"), []byte("12345"), time.Time{})
+	node.store.set(NamespacedKey("team", "b"), []byte("123"), time.Time{})
+	node.store.set(NamespacedKey("other", "a"), []byte("1"), time.Time{})
+	if usage := node.usage("team"); usage.Keys != 2 || usage.Bytes != 8 {
+		t.Fatalf("usage = %+v", usage)
+	}
+
+	node.store.set(NamespacedKey("team", "a"), []byte("1"), time.Time{})
+	node.store.tombstone(NamespacedKey("team", "b"), time.Now().Add(time.Hour))
+	if usage := node.usage("team"); usage.Keys != 1 || usage.Bytes != 1 {
+		t.Errorf("usage = %+v after a rewrite and a delete", usage)
+	}
+
+	node.store.del([]string{NamespacedKey("team", "a"), NamespacedKey("team", "b")})
+	if usage := node.usage("team"); usage.Keys != 0 {
+		t.Errorf("usage = %+v once every key is gone", usage)
+	}
+	if usage := node.usage("other"); usage.Keys != 1 || usage.Bytes != 1 {
+		t.Errorf("usage of other namespace = %+v", usage)
+	}
+}
+
+func TestNamespacesRequireAuth(t *testing.T) {
+	config := testConfig(freeAddress(t), "")
+	config.Namespaces = ringNamespaces
+	if node, err := CreateNewNodeWithConfig(config); err != ErrNamespacesWithoutAuth {
+		if err == nil {
+			node.Stop()
+		}
+		t.Errorf("node with namespaces and without auth: %v", err)
+	}
+}
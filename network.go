@@ -1,44 +1,72 @@
 package chord
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
 	"database/sql"
-	"fmt"
-	"io"
-	"log"
 	"net"
 	"net/http"
-	"net/rpc"
 	"path/filepath"
 	"runtime"
 	"time"
 )
 
+// Creates a node listening on address. If joinNodeAddr
+// is empty a new network is created else the node joins
+// the network of node at joinNodeAddr
 func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
+	return CreateNewNodeWithConfig(DefaultConfig(address, joinNodeAddr))
+}
+
+// Creates a node as per the given config
+func CreateNewNodeWithConfig(config *Config) (*RPCNode, error) {
 	// Initially do not skip deferred functions
 	// deferred functions are to be skipped in
 	// case of errors
 	skipDefer := false
 
+	settings := config.withDefaults()
+	address := settings.Address
+	joinNodeAddr := settings.JoinAddress
 	id := getHash(address)
-
-	// Discards logger warnings regarding Save and Stop
-	// Non RPC methods not having signature required as per
-	// net/rpc package
-	log.SetOutput(io.Discard)
+	if settings.Identity != nil {
+		id = identityId(settings.Identity.Public().(ed25519.PublicKey))
+	}
 
 	// Initialize RPC node
 	node := &RPCNode{
 		Node: &Node{
 			id:              id,
+			config:          settings,
+			logger:          newNodeLogger(settings.Logger, address, id),
 			address:         address,
 			predecessorId:   nil,
 			predecessorRPC:  nil,
 			predecessorAddr: "",
-			store:           make(dataStore),
+			store:           newDataStore(),
 			exitCh:          make(chan struct{}),
+			stopped:         make(chan struct{}),
+			transfers:       make(map[string]TransferInfo),
+			incoming:        make(map[string]*incomingTransfer),
+			hints:           make(map[string]map[string]hint),
+			fragments:       make(map[fragmentId]storedFragment),
+			usages:          make(map[string]Usage),
+			limiter:         newLimiter(),
+			metrics:         newMetrics(),
 		},
 	}
 
+	// clients could bypass the ACLs through the
+	// internal methods if anyone may call them
+	if len(settings.Namespaces) > 0 && !node.authEnabled() {
+		return nil, ErrNamespacesWithoutAuth
+	}
+
+	node.store.changed = node.countUsage
+	if err := node.openStore(); err != nil {
+		return nil, err
+	}
+
 	// Initialize connection to database
 
 	_, b, _, _ := runtime.Caller(0)
@@ -46,25 +74,26 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 	node.db, _ = sql.Open("sqlite3", dbPath)
 
 	// start rpc server for node and listen
-	// for connections
-	var err error
-
-	err = rpc.Register(node)
+	// for connections. metrics are served on
+	// the same listener under /metrics
+	handler, err := node.newHandler()
 	if err != nil {
 		skipDefer = true
 		return nil, err
 	}
-	rpc.HandleHTTP()
 
 	node.listener, err = net.Listen("tcp", address)
 	if err != nil {
 		skipDefer = true
 		return nil, ErrUnableToListen
 	}
-	go http.Serve(node.listener, nil)
+	if settings.TLS != nil {
+		node.listener = tls.NewListener(node.listener, settings.TLS)
+	}
+	go http.Serve(node.listener, handler)
 
 	// create rpc client for node and save it
-	client, err := rpc.DialHTTP("tcp", address)
+	client, err := node.getClient(address)
 	if err != nil {
 		skipDefer = true
 		return nil, ErrUnableToDial
@@ -76,14 +105,15 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 	// and is not updated if there aren't any other
 	// nodes in the network i.e. joinNodeAddr was empty
 	node.fingerTable = make([]*Finger, 30)
-	node.fingerTable[0] = &Finger{node.id, node.address}
+	node.fingerTable[0] = &Finger{node.id, node.address, time.Now()}
+	node.successorList = []*Finger{{node.id, node.address, time.Now()}}
 
-	go saveNode(node.db, node.address, node.fingerTable[0].address)
+	go saveNode(node.db, node.logger, node.address, node.fingerTable[0].address)
 
 	// prediodically checks if predecessor has failed
 	defer func() {
 		if skipDefer {
-			fmt.Println("Skipping predecessor checks")
+			node.logger.Debug("skipping predecessor checks")
 			return
 		}
 		go func() {
@@ -103,7 +133,7 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 	// prediodically fix finger table
 	defer func() {
 		if skipDefer {
-			fmt.Println("Skipping finger fixes")
+			node.logger.Debug("skipping finger fixes")
 			return
 		}
 		go func() {
@@ -127,7 +157,7 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 	// prediodically stablize the node
 	defer func() {
 		if skipDefer {
-			fmt.Println("Skipping stabilize")
+			node.logger.Debug("skipping stabilize")
 			return
 		}
 		go func() {
@@ -144,23 +174,86 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 		}()
 	}()
 
+	// prediodically remove expired keys
+	defer func() {
+		if skipDefer {
+			node.logger.Debug("skipping expiry")
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(node.config.ExpiryInterval)
+			for {
+				select {
+				case <-ticker.C:
+					node.expireKeys()
+					node.expireTransfers()
+					node.expireBuckets(time.Now())
+					node.rotateMasterKeys()
+				case <-node.exitCh:
+					ticker.Stop()
+					return
+				}
+			}
+		}()
+	}()
+
+	// prediodically replay hints
+	defer func() {
+		if skipDefer {
+			node.logger.Debug("skipping hint replay")
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(node.config.HintReplayInterval)
+			for {
+				select {
+				case <-ticker.C:
+					node.replayHints()
+				case <-node.exitCh:
+					ticker.Stop()
+					return
+				}
+			}
+		}()
+	}()
+
+	// prediodically repair replicas
+	defer func() {
+		if skipDefer {
+			node.logger.Debug("skipping anti-entropy")
+			return
+		}
+		go func() {
+			ticker := time.NewTicker(node.config.AntiEntropyInterval)
+			for {
+				select {
+				case <-ticker.C:
+					node.antiEntropy()
+					node.repairFragments()
+				case <-node.exitCh:
+					ticker.Stop()
+					return
+				}
+			}
+		}()
+	}()
+
 	// empty join address implies creation of
 	// new network, hence return the new node
 	if joinNodeAddr == "" {
-		fmt.Printf("============ New Network ============\n\n")
-		fmt.Printf("Node: %v\nNode ID: %v\n",
-			node.address,
-			toBigInt(node.id),
-		)
+		node.logger.Info("created new network")
 		return node, nil
 	}
 
 	// Non empty joinNodeAddr implies
 	// this node has to join exitsting network
 
-	joinNodeClient, err := getClient(joinNodeAddr)
+	joinNodeClient, err := node.getClient(joinNodeAddr)
 	if err != nil {
 		skipDefer = true
+		if err == ErrUnauthenticated {
+			return nil, err
+		}
 		return nil, ErrUnableToDial
 	}
 
@@ -169,9 +262,13 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 	joinNodeClient.Call("RPCNode.Successor", node.id, &successorAddr)
 	joinNodeClient.Close()
 
-	successorRPC, _ := getClient(successorAddr)
-	var successorId []byte
-	successorRPC.Call("RPCNode.GetId", "", &successorId)
+	successorRPC, _ := node.getClient(successorAddr)
+	successorId, err := node.peerId(successorRPC, successorAddr)
+	if err != nil && node.config.Identity != nil {
+		successorRPC.Close()
+		skipDefer = true
+		return nil, err
+	}
 
 	if equal(successorId, node.id) {
 		// Node with same ID already exists in the
@@ -183,19 +280,16 @@ func CreateNewNode(address string, joinNodeAddr string) (*RPCNode, error) {
 	// update first finger to point to successor
 	node.fingerTable[0].id = successorId
 	node.fingerTable[0].address = successorAddr
+	node.fingerTable[0].updated = time.Now()
 
 	// update db
-	go updateSuccessor(node.db, node.address, successorAddr)
+	go updateSuccessor(node.db, node.logger, node.address, successorAddr)
 
 	// notify successor that new node might
 	// be its new predecessor
-	successorRPC.Call("RPCNode.Notify", node.address, "")
+	successorRPC.Call("RPCNode.Notify", node.announce(), "")
 	successorRPC.Close()
 
-	fmt.Printf("============ Joining Node ============\n\n")
-	fmt.Printf("Node: %v\nNode ID: %v\n",
-		node.address,
-		toBigInt(node.id),
-	)
+	node.logger.Info("joined network", "via", joinNodeAddr, "successor", successorAddr)
 	return node, nil
 }
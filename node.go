@@ -2,10 +2,10 @@ package chord
 
 import (
 	"database/sql"
-	"fmt"
 	"math/big"
 	"net"
 	"net/rpc"
+	"sort"
 	"sync"
 	"time"
 )
@@ -41,23 +41,90 @@ type Node struct {
 	// Stores address of predecessor node
 	predecessorAddr string
 
+	// time at which predecessor was last set
+	// or confirmed to be alive
+	predecessorUpdated time.Time
+
 	// fingerTable contains the list of fingers
 	// associated with a node.
 	fingerTable []*Finger
 
+	// successorList contains the first few nodes
+	// following the node, its first entry is
+	// the successor.
+	successorList []*Finger
+
+	// transfers of keys to other nodes which are
+	// in progress, keyed by transfer id
+	transfers map[string]TransferInfo
+
+	// transfers of keys to this node,
+	// keyed by transfer id
+	incoming map[string]*incomingTransfer
+
+	// outcome of the latest stabilize round
+	lastStabilize StabilizeInfo
+
+	// hints held for unreachable nodes keyed by their
+	// address and then by Key, and their total count
+	hints     map[string]map[string]hint
+	hintCount int
+
+	// replicas which follow the node in its chain, see
+	// chain.go. chainMutex is held for reading by writes
+	// passing down the chain and for writing while the
+	// chain is reconfigured.
+	chain      []string
+	chainMutex sync.RWMutex
+
+	// fragments of erasure coded Values held by
+	// the node, keyed by Key and fragment index,
+	// and the bytes of their data
+	fragments     map[fragmentId]storedFragment
+	fragmentBytes int
+
 	// store stores the Key-Value pairs assigned to
 	// the node.
-	store dataStore
+	store *dataStore
+
+	// modification time of Config.MasterKeyFile when
+	// it was last read, and whether records are being
+	// rewrapped, see rotateMasterKeys
+	masterKeysRead time.Time
+	rotating       bool
+
+	// usage of each namespace among the keys
+	// the node owns, see countUsage
+	usages map[string]Usage
 
 	// channel to indicate node is exiting
 	exitCh chan struct{}
 
+	// closed once node has stopped
+	stopped chan struct{}
+
+	// makes sure node is stopped only once
+	stopOnce sync.Once
+
 	// RW Mutex lock can be held by arbitary
 	// no. of readers or a single writer
 	mutex sync.RWMutex
 
 	// Store data in sqlite db to sync with frontend
 	db *sql.DB
+
+	// instrumentation exposed on /metrics
+	metrics *metrics
+
+	// admits the requests of clients as per
+	// the rate limits of the node
+	limiter *limiter
+
+	// config with which node was created
+	config Config
+
+	// logs messages along with node's id and address
+	logger Logger
 }
 
 // Each ith finger represents the node which is
@@ -70,6 +137,84 @@ type Finger struct {
 
 	// address of n + 2^(i - 1) node
 	address string
+
+	// time at which the finger was last updated
+	updated time.Time
+}
+
+// Lookup is the result of resolving the
+// successor of an id
+type Lookup struct {
+	// address and id of the successor
+	Address string
+	Id      ID
+
+	// addresses of the nodes the lookup passed
+	// through in order, starting with the node
+	// where the lookup began
+	Path []string
+}
+
+// Find the successor of given id. Nodes through
+// which the lookup is forwarded are appended to
+// lookup.Path
+func (node *Node) findSuccessor(id []byte, lookup *Lookup) error {
+	lookup.Path = append(lookup.Path, node.address)
+
+	// If the id is between node and its successor
+	// then return the successor
+	node.mutex.RLock()
+	if betweenRightInc(id, node.id, node.fingerTable[0].id) {
+		lookup.Address = node.fingerTable[0].address
+		lookup.Id = node.fingerTable[0].id
+		node.mutex.RUnlock()
+		return nil
+	}
+	node.mutex.RUnlock()
+
+	// find the closest preceeding node for given id.
+	// If the closest preceeding node and current
+	// node are same, return the address of current node
+	pred, address := node.closest_preceeding_node(id)
+	if address == node.address {
+		lookup.Address = address
+		lookup.Id = node.id
+		return nil
+	}
+	defer pred.Close()
+
+	// If they are different, forward the lookup
+	// to closest preceeding node and return its result
+	var next Lookup
+	err := pred.Call("RPCNode.FindSuccessor", id, &next)
+	lookup.Address = next.Address
+	lookup.Id = next.Id
+	lookup.Path = append(lookup.Path, next.Path...)
+	return err
+}
+
+// Find the successor of given id starting from this node,
+// by redundant lookups if Config.SecureLookups is set, and
+// record the lookup in node's metrics. The Address is empty
+// if none of the redundant lookups reached a node.
+func (node *Node) resolve(id []byte) Lookup {
+	var lookup Lookup
+	if node.config.SecureLookups > 1 {
+		secure, err := node.secureLookup(id, node.config.SecureLookups)
+		if err != nil {
+			return Lookup{}
+		}
+		lookup = secure.Lookup
+	} else {
+		node.findSuccessor(id, &lookup)
+	}
+	node.metrics.observeLookup(len(lookup.Path) - 1)
+	return lookup
+}
+
+// Returns address of the successor of given id
+func (node *Node) lookup(id []byte) string {
+	return node.resolve(id).Address
 }
 
 // Find the finger just preceeding the given id from
@@ -91,7 +236,7 @@ func (node *Node) closest_preceeding_node(id []byte) (*rpc.Client, string) {
 		}
 
 		if between(finger.id, node.id, id) {
-			client, err := getClient(finger.address)
+			client, err := node.getClient(finger.address)
 			if err != nil {
 				// If we are not able to get client of the closest
 				// finger. Try remaining fingers.
@@ -125,13 +270,23 @@ func (node *Node) checkPredecessor() error {
 	select {
 	case <-callReply.Done:
 		if reply != "Acknowledged" {
+			node.metrics.predecessorResets.inc()
 			node.makePredecessorNil()
+			go node.reconfigureChain(true)
 			return ErrFailedToReach
 		}
 	case <-time.NewTimer(5 * time.Second).C:
+		node.metrics.predecessorResets.inc()
 		node.makePredecessorNil()
+		go node.reconfigureChain(true)
 		return ErrFailedToReach
 	}
+
+	node.mutex.Lock()
+	if node.predecessorRPC == myPred {
+		node.predecessorUpdated = time.Now()
+	}
+	node.mutex.Unlock()
 	return nil
 }
 
@@ -148,6 +303,8 @@ func (node *Node) makePredecessorNil() {
 	node.predecessorId = nil
 	node.predecessorRPC = nil
 	node.predecessorAddr = ""
+	node.predecessorUpdated = time.Time{}
+	node.recountUsage()
 }
 
 // Check if current successor has failed
@@ -156,7 +313,7 @@ func (node *Node) checkSuccessor() *rpc.Client {
 
 	// try to get successor rpc
 	node.mutex.RLock()
-	successor, err := getClient(node.fingerTable[0].address)
+	successor, err := node.getClient(node.fingerTable[0].address)
 	node.mutex.RUnlock()
 
 	// if we are unable to dail address of successor's rpc server
@@ -166,7 +323,7 @@ func (node *Node) checkSuccessor() *rpc.Client {
 			time.Sleep(time.Second)
 
 			node.mutex.RLock()
-			successor, err = getClient(node.fingerTable[0].address)
+			successor, err = node.getClient(node.fingerTable[0].address)
 			node.mutex.RUnlock()
 
 			if err == nil {
@@ -193,12 +350,15 @@ func (node *Node) makeSuccessorNil() {
 	defer node.mutex.Unlock()
 	node.fingerTable[0].id = node.id
 	node.fingerTable[0].address = node.address
-	go updateSuccessor(node.db, node.address, node.fingerTable[0].address)
+	node.fingerTable[0].updated = time.Now()
+	go updateSuccessor(node.db, node.logger, node.address, node.fingerTable[0].address)
 
 }
 
 // Fixes the i'th finger
 func (node *RPCNode) fixFinger(i int) int {
+	node.metrics.fixFingerRounds.inc()
+
 	// find successor of i th offset and
 	// set it as i th finger of current node
 
@@ -206,7 +366,7 @@ func (node *RPCNode) fixFinger(i int) int {
 	var successorAddr string
 	node.Successor(fingerId, &successorAddr)
 
-	successorRPC, err := getClient(successorAddr)
+	successorRPC, err := node.getClient(successorAddr)
 
 	if err != nil {
 		// keep trying to dial rpc server for given
@@ -215,7 +375,7 @@ func (node *RPCNode) fixFinger(i int) int {
 		for ; err.Error() == ErrUnableToDial.Error() && try > 0; try-- {
 			time.Sleep(time.Second)
 			node.Successor(fingerId, &successorAddr)
-			successorRPC, err = getClient(successorAddr)
+			successorRPC, err = node.getClient(successorAddr)
 			if err == nil {
 				// successfully dailed rpc server.
 				// break out of loop
@@ -223,19 +383,18 @@ func (node *RPCNode) fixFinger(i int) int {
 			}
 		}
 		if try <= 0 {
+			node.metrics.fixFingerFailures.inc()
 			return i
 		}
 	}
 
 	// get id of successor of fingerId
-	var successorId []byte
-	if successorAddr != node.address {
-		successorRPC.Call("RPCNode.GetId", "", &successorId)
-	} else {
-		successorId = node.id
-	}
-
+	successorId, err := node.peerId(successorRPC, successorAddr)
 	successorRPC.Close()
+	if err != nil {
+		node.metrics.fixFingerFailures.inc()
+		return i
+	}
 
 	node.mutex.Lock()
 
@@ -247,9 +406,10 @@ func (node *RPCNode) fixFinger(i int) int {
 
 	node.fingerTable[i].id = successorId
 	node.fingerTable[i].address = successorAddr
+	node.fingerTable[i].updated = time.Now()
 
 	if i == 0 {
-		go updateSuccessor(node.db, node.address, successorAddr)
+		go updateSuccessor(node.db, node.logger, node.address, successorAddr)
 	}
 	node.mutex.Unlock()
 
@@ -297,12 +457,27 @@ func fingerId(n []byte, i int) []byte {
 // and check if it is better suited to be the successor
 // of current node.
 func (node *Node) stabilize() {
+	node.metrics.stabilizeRounds.inc()
+
+	// failure, if any, is recorded as the
+	// outcome of this round
+	var failure error
+	defer func() {
+		if failure == nil {
+			failure = node.updateSuccessorList()
+		}
+		if failure != nil {
+			node.metrics.stabilizeFailures.inc()
+		}
+		node.recordStabilize(failure)
+	}()
+
 	// get rpc client of successor
 	node.mutex.RLock()
 	successor := node.fingerTable[0]
 	node.mutex.RUnlock()
 
-	successorRPC, err := getClient(successor.address)
+	successorRPC, err := node.getClient(successor.address)
 	if err != nil && err.Error() == ErrUnableToDial.Error() {
 		successorRPC = node.checkSuccessor()
 	}
@@ -317,19 +492,25 @@ func (node *Node) stabilize() {
 		// and we are not our own successor.
 		if err.Error() == ErrNilPredecessor.Error() && !equal(node.id, successor.id) {
 			// Notify our successor that we might be its predecessor
-			successorRPC.Call("RPCNode.Notify", node.address, "")
+			successorRPC.Call("RPCNode.Notify", node.announce(), "")
 			return
 		}
 		// if error was not ErrNilPredecessor
 		// or we are our own successor, then do nothing.
+		if err.Error() != ErrNilPredecessor.Error() {
+			failure = err
+		}
 		return
 	}
 
-	successorPredRPC, _ := getClient(successorPredAddr)
+	successorPredRPC, _ := node.getClient(successorPredAddr)
 	defer successorPredRPC.Close()
 
-	var successorPredId []byte
-	successorPredRPC.Call("RPCNode.GetId", "", &successorPredId)
+	successorPredId, err := node.peerId(successorPredRPC, successorPredAddr)
+	if err != nil {
+		failure = err
+		return
+	}
 
 	// check if our successor's predecessor is a viable replacement
 	// for our successor. If it is replace our successor and notify
@@ -338,30 +519,30 @@ func (node *Node) stabilize() {
 		node.mutex.Lock()
 		node.fingerTable[0].id = successorPredId
 		node.fingerTable[0].address = successorPredAddr
+		node.fingerTable[0].updated = time.Now()
 
-		go updateSuccessor(node.db, node.address, successorPredAddr)
+		go updateSuccessor(node.db, node.logger, node.address, successorPredAddr)
 
 		node.mutex.Unlock()
-		successorPredRPC.Call("RPCNode.Notify", node.address, "")
+		successorPredRPC.Call("RPCNode.Notify", node.announce(), "")
 	}
 	successorPredRPC.Close()
 }
 
-// Wrapper to dataStore.del
-func (node *Node) deleteKeys(keys []string) {
-	node.store.del(keys)
-}
-
 // This method is called when node is leaving the
 // chord network. It does the following tasks
 // 	1.transfers its keys to its successor
 // 	2.connect its predecessor and successor to
 // 	  each other
 func (node *Node) Stop() {
-	fmt.Println("\nStoping -", toBigInt(node.id))
+	node.stopOnce.Do(node.stop)
+}
+
+func (node *Node) stop() {
+	node.logger.Info("stopping node")
 	var wg sync.WaitGroup
 	wg.Add(1)
-	go deleteNode(node.db, node.address, &wg)
+	go deleteNode(node.db, node.logger, node.address, &wg)
 
 	close(node.exitCh)
 
@@ -372,7 +553,7 @@ func (node *Node) Stop() {
 	// if the successor is known, transfer it the data
 	if successor.id != nil && !equal(successor.id, node.id) {
 		node.transferData(successor.address)
-		successorRPC, _ := getClient(successor.address)
+		successorRPC, _ := node.getClient(successor.address)
 		// if predecessor if know, connect our successor
 		// and predecessor to each other.
 		if node.predecessorId != nil {
@@ -386,82 +567,205 @@ func (node *Node) Stop() {
 	node.self.Close()
 	node.listener.Close()
 	wg.Wait()
+
+	// writes still in flight stay in memory
+	node.mutex.Lock()
+	if node.store.engine != nil {
+		node.store.engine.Close()
+		node.store.engine = nil
+	}
+	node.mutex.Unlock()
+	close(node.stopped)
 }
 
-// Saves Key-Value pair in chord network
-func (node *Node) save(key string, value []byte) string {
-	fmt.Printf("Save %q : %q\n", key, value)
+// Returns a channel which is closed
+// once the node has stopped
+func (node *Node) Stopped() <-chan struct{} {
+	return node.stopped
+}
 
-	var saveNodeAddr string
+// Saves Key-Value pair in chord network and returns
+// the address of the node where it was stored
+func (node *Node) save(key string, value []byte) (string, error) {
+	node.logger.Debug("saving key", "key", key, "value", node.redact(value))
 
-	// get hash of Key
-	keyHash := getHash(key)
+	// save the data on the node suitable to store
+	// the Key, or hand it off if it is unreachable
+	var result WriteResult
+	err := node.write(&WriteRequest{Key: key, Value: value}, &result)
+	return result.Node, err
+}
 
-	// find the node suitable to store the Key and
-	// get its rpc client
-	node.self.Call("RPCNode.Successor", keyHash, &saveNodeAddr)
-	saveNode, err := getClient(saveNodeAddr)
+// Deletes the expired Key-Value pairs
+// from node's store
+func (node *Node) expireKeys() {
+	node.mutex.Lock()
+	count := node.store.expire(time.Now())
+	node.expireFragments(time.Now())
+	node.mutex.Unlock()
 
-	if err != nil {
-		for err.Error() == ErrUnableToDial.Error() {
-			node.self.Call("RPCNode.Successor", keyHash, &saveNodeAddr)
-			saveNode, err = getClient(saveNodeAddr)
+	if count > 0 {
+		node.metrics.keysExpired.add(count)
+		node.logger.Debug("expired keys", "keys", count)
+	}
+}
+
+// Deletes a Key-Value pair from chord network
+func (node *Node) delete(key string) (string, error) {
+	var result WriteResult
+	err := node.write(&WriteRequest{Key: key, Delete: true}, &result)
+	return result.Node, err
+}
+
+// Applies a write to node's store and returns the
+// version written, or deleted. node.mutex must be
+// held by the caller.
+func (node *Node) applyWrite(req *WriteRequest) (version uint64, err error) {
+	current, ok := node.store.get(req.Key)
+	if req.Conditional && current.Version != req.Version {
+		return 0, ErrVersionMismatch
+	}
+	if version, exists, err := node.checkBlob(req); err != nil || exists {
+		return version, err
+	}
+	if err := node.checkQuota(req); err != nil {
+		return 0, err
+	}
+	if err := node.checkSize(req); err != nil {
+		return 0, err
+	}
+	if err := node.checkCapacity(node.growth(req)); err != nil {
+		return 0, err
+	}
+
+	// fragments of the replaced Value are dropped
+	// once the write has been applied
+	if stored, _ := node.store.stored(req.Key); stored.Erasure != nil {
+		defer func() {
+			if err == nil {
+				go node.dropFragments(req.Key, stored.Erasure)
+			}
+		}()
+	}
+
+	if req.Delete {
+		if !ok {
+			return 0, ErrNoKeyValuePair
 		}
+		tombstoneExpires := time.Now().Add(node.config.TombstoneTTL)
+		if node.config.VectorClocks {
+			node.store.setSibling(req.Key, nil, true, req.Context, node.address, tombstoneExpires)
+		} else {
+			node.store.tombstone(req.Key, tombstoneExpires)
+		}
+		return current.Version, nil
 	}
 
-	data := make(dataStore)
-	data[key] = value
+	var expires time.Time
+	if req.TTL > 0 {
+		expires = time.Now().Add(req.TTL)
+	}
 
-	// save the data on the node
-	saveNode.Call("RPCNode.SetData", &data, "")
-	saveNode.Close()
-	return saveNodeAddr
+	node.logger.Debug("writing key", "key", req.Key, "value", node.redact(req.Value), "ttl", req.TTL)
+	if node.config.VectorClocks {
+		return node.store.setSibling(req.Key, req.Value, false, req.Context, node.address, expires), nil
+	}
+	return node.store.setItem(req.Key, Item{Value: req.Value, Expires: expires, Erasure: req.Erasure}), nil
 }
 
-// Transfer data to the node whose address is given by
-// "to" parameter
-func (node *Node) transferData(to string) {
-	toRPC, err := getClient(to)
+// Applies a write to a Key at the node which owns it.
+// If the owner cannot be reached unconditional writes
+// at consistency ONE are handed off to the next node.
+func (node *Node) write(req *WriteRequest, result *WriteResult) error {
+	if err := node.checkSize(req); err != nil {
+		return err
+	}
+	lookup := node.resolve(getHash(req.Key))
+	if lookup.Address == "" {
+		return ErrFailedToReach
+	}
 
+	// large Values are written as fragments and
+	// the Erasure is written in their place
+	var fragments int
+	if node.erasureCoded(req) {
+		erasure, stored, err := node.storeFragments(lookup, req)
+		if err != nil {
+			return err
+		}
+		coded := *req
+		coded.Value, coded.Erasure = nil, erasure
+		req, fragments = &coded, stored
+
+		defer func() {
+			result.Fragments = fragments
+			if result.Version == 0 {
+				// the write was not applied
+				go node.dropFragments(req.Key, erasure)
+			}
+		}()
+	}
+
+	owner, err := node.getClient(lookup.Address)
 	if err != nil {
-		fmt.Println("TransferData", err)
-		return
+		if req.Conditional || req.Consistency != ConsistencyOne {
+			return err
+		}
+		return node.handoff(lookup, req, result)
 	}
+	defer owner.Close()
 
-	defer toRPC.Close()
+	err = owner.Call("RPCNode.ApplyWrite", req, result)
+	result.Node = lookup.Address
+	return err
+}
 
+// Transfer data to the node whose address is given by
+// "to" parameter
+func (node *Node) transferData(to string) {
 	var toId []byte
 
 	node.mutex.RLock()
+	successor := *node.fingerTable[0]
+	node.mutex.RUnlock()
+
 	// if transfering data to successor used the
 	// saved id. For other nodes initiate rpc
 	// to get the id for that node.
-	if to == node.fingerTable[0].address {
-		toId = node.fingerTable[0].id
+	if to == successor.address {
+		toId = successor.id
 	} else {
-		toRPC.Call("RPCNode.GetId", "", &toId)
+		toRPC, err := node.getClient(to)
+		if err != nil {
+			node.logger.Warn("unable to transfer data", "to", to, "err", err)
+			return
+		}
+		toId, err = node.peerId(toRPC, to)
+		toRPC.Close()
+		if err != nil {
+			node.logger.Warn("unable to transfer data", "to", to, "err", err)
+			return
+		}
 	}
-	node.mutex.RUnlock()
 
 	// get which data to transfer
-	delKeys, transfer := node.getTransferRange(to, toId)
+	keys := node.getTransferRange(to, toId)
 
-	// transfer the data
-	toRPC.Call("RPCNode.SetData", &transfer, "")
+	// stream the data, keys are deleted from
+	// this node as they are received
+	if err := node.streamKeys(to, keys); err != nil {
+		node.logger.Warn("unable to transfer data", "to", to, "err", err)
+	}
 
-	// delete data from this node
-	node.deleteKeys(delKeys)
+	// fragments held for the keys go along with them
+	if err := node.transferFragments(to, toId); err != nil {
+		node.logger.Warn("unable to transfer fragments", "to", to, "err", err)
+	}
 }
 
-// Finds and returns which Key-Value pairs are eligible for transfer
-func (node *Node) getTransferRange(to string, toID []byte) ([]string, dataStore) {
-	delKeys := make([]string, 0)
-	transfer := make(dataStore)
-
-	fmt.Println("Transfering to", to)
-
-	node.mutex.RLock()
-
+// Check if every key is to be transferred to the node
+// with id toID. node.mutex must be held by the caller.
+func (node *Node) transfersAll(toID []byte) bool {
 	// check if node is stopping.
 	// Value of ok will be changed
 	// to false if it is stopping.
@@ -474,27 +778,35 @@ func (node *Node) getTransferRange(to string, toID []byte) ([]string, dataStore)
 	// transfer all data to successor only if successor
 	// node and predecessor node are not same or if the
 	// current node is stopping
-	if !ok ||
+	return !ok ||
 		(equal(toID, node.fingerTable[0].id) &&
-			!equal(node.fingerTable[0].id, node.predecessorId)) {
-		transfer = node.store
-		for key := range node.store {
-			delKeys = append(delKeys, key)
-		}
+			!equal(node.fingerTable[0].id, node.predecessorId))
+}
+
+// Finds and returns which Keys are eligible for transfer
+// in the order in which they are to be transferred
+func (node *Node) getTransferRange(to string, toID []byte) []string {
+	keys := make([]string, 0)
+
+	node.mutex.RLock()
+	if node.transfersAll(toID) {
+		node.store.each(func(key string, _ Item) {
+			keys = append(keys, key)
+		})
 	} else {
 		// else trasnfer only selected keys
 		//
 		// transfer keys from current node which do not lie
 		// in the interval between toId and node.id (node.id inclusive)
-		for key, value := range node.store {
+		node.store.each(func(key string, _ Item) {
 			if !betweenRightInc(getHash(key), toID, node.id) {
-				delKeys = append(delKeys, key)
-				transfer[key] = value
+				keys = append(keys, key)
 			}
-		}
+		})
 	}
 	node.mutex.RUnlock()
 
-	fmt.Println(transfer)
-	return delKeys, transfer
+	sort.Strings(keys)
+	node.logger.Debug("transferring keys", "to", to, "keys", len(keys))
+	return keys
 }
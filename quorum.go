@@ -0,0 +1,305 @@
+package chord
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Consistency is the number of replicas of a Key
+// which must answer a read or acknowledge a write
+type Consistency int
+
+const (
+	// the owner alone for writes, any one replica for reads
+	ConsistencyOne Consistency = iota
+
+	// a majority of the replicas
+	ConsistencyQuorum
+
+	// every replica
+	ConsistencyAll
+
+	// writes pass down the chain of replicas from the
+	// owner, the head, to the tail and reads are served
+	// by the tail, see chain.go
+	ConsistencyLinearizable
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case ConsistencyOne:
+		return "ONE"
+	case ConsistencyQuorum:
+		return "QUORUM"
+	case ConsistencyAll:
+		return "ALL"
+	case ConsistencyLinearizable:
+		return "LINEARIZABLE"
+	}
+	return fmt.Sprintf("Consistency(%d)", int(c))
+}
+
+// ParseConsistency returns the Consistency
+// named by s i.e. one, quorum, all or linearizable
+func ParseConsistency(s string) (Consistency, error) {
+	for _, c := range []Consistency{ConsistencyOne, ConsistencyQuorum, ConsistencyAll, ConsistencyLinearizable} {
+		if strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown consistency %q", s)
+}
+
+// Returns the number of answers needed
+// out of the given number of replicas
+func (c Consistency) required(replicas int) int {
+	switch c {
+	case ConsistencyQuorum:
+		return replicas/2 + 1
+	case ConsistencyAll, ConsistencyLinearizable:
+		return replicas
+	}
+	return 1
+}
+
+// ReadRequest is a read of a single Key
+type ReadRequest struct {
+	Key         string
+	Consistency Consistency
+}
+
+// ReadResult is the outcome of a read
+type ReadResult struct {
+	// newest Item among the replicas which answered
+	Item Item
+
+	// number of replicas which answered and
+	// the number of replicas of the Key
+	Replicas int
+	Total    int
+
+	// address of the node which owns the Key
+	Node string
+}
+
+// ReadRepairMode is how replicas found stale
+// by a read are repaired
+type ReadRepairMode int
+
+const (
+	// the read returns first and stale
+	// replicas are repaired in the background
+	ReadRepairAsync ReadRepairMode = iota
+
+	// the read returns once the stale replicas
+	// among those which answered are repaired
+	ReadRepairSync
+
+	// stale replicas are left to anti-entropy
+	ReadRepairOff
+)
+
+// replicaRead is the answer of a single replica
+type replicaRead struct {
+	address string
+	item    Item
+	found   bool
+	err     error
+}
+
+// Reads a Key from its replicas, the owner and the nodes
+// following it, and returns the newest Item once enough
+// replicas have answered as per req.Consistency
+func (node *Node) read(req *ReadRequest, result *ReadResult) error {
+	result.Node = node.lookup(getHash(req.Key))
+	if req.Consistency == ConsistencyLinearizable {
+		return node.readTail(req, result)
+	}
+
+	owner, err := node.getClient(result.Node)
+	if err != nil {
+		return err
+	}
+
+	var replicas []string
+	err = owner.Call("RPCNode.ReplicaSet", "", &replicas)
+	owner.Close()
+	if err != nil {
+		return err
+	}
+
+	result.Total = len(replicas)
+	need := req.Consistency.required(len(replicas))
+
+	reads := make(chan replicaRead, len(replicas))
+	for _, replica := range replicas {
+		go func(replica string) {
+			replicaRPC, err := node.getClient(replica)
+			if err != nil {
+				reads <- replicaRead{address: replica, err: err}
+				return
+			}
+			defer replicaRPC.Close()
+
+			var item Item
+			err = replicaRPC.Call("RPCNode.ReadReplica", &req.Key, &item)
+			if err != nil && err.Error() == ErrNoKeyValuePair.Error() {
+				reads <- replicaRead{address: replica}
+				return
+			}
+			reads <- replicaRead{address: replica, item: item, found: err == nil, err: err}
+		}(replica)
+	}
+
+	answered := make([]replicaRead, 0, len(replicas))
+	for range replicas {
+		read := <-reads
+		answered = append(answered, read)
+		if read.err == nil {
+			result.Replicas++
+		}
+		if result.Replicas >= need {
+			break
+		}
+	}
+	node.repairReplicas(req.Key, answered, reads, len(replicas)-len(answered))
+
+	if result.Replicas < need {
+		return ErrNotEnoughReplicas
+	}
+
+	var found bool
+	result.Item, found = newest(answered)
+	if !found || result.Item.Deleted {
+		result.Item = Item{}
+		return ErrNoKeyValuePair
+	}
+	if result.Item.Erasure != nil {
+		return node.rebuild(req.Key, &result.Item)
+	}
+	return nil
+}
+
+// Returns the newest Item among the reads of replicas,
+// with siblings reconciled, false if no replica had the Key
+func newest(reads []replicaRead) (Item, bool) {
+	var item Item
+	found := false
+	for _, read := range reads {
+		if read.err != nil || !read.found {
+			continue
+		}
+		if found {
+			item = reconcile(item, read.item)
+		} else {
+			item, found = read.item, true
+		}
+	}
+	return item, found
+}
+
+// Writes the newest Item back to the replicas which answered
+// a read with an older one, as per Config.ReadRepair. Replicas
+// still to answer are read from late and repaired in the
+// background once they do.
+func (node *Node) repairReplicas(key string, answered []replicaRead, late <-chan replicaRead, remaining int) {
+	mode := node.config.ReadRepair
+	if mode == ReadRepairOff {
+		return
+	}
+
+	if mode == ReadRepairSync {
+		node.readRepair(key, answered)
+		if remaining == 0 {
+			return
+		}
+	}
+
+	reads := append([]replicaRead(nil), answered...)
+	go func() {
+		for i := 0; i < remaining; i++ {
+			reads = append(reads, <-late)
+		}
+		node.readRepair(key, reads)
+	}()
+}
+
+// Writes the newest Item among reads to the replicas
+// whose copy is older. The reads of repaired replicas
+// are updated to hold the newest Item.
+func (node *Node) readRepair(key string, reads []replicaRead) {
+	item, found := newest(reads)
+	if !found {
+		return
+	}
+	items := []TransferItem{{key, item}}
+
+	for i := range reads {
+		read := &reads[i]
+		if read.err != nil || (read.found && !newer(item, read.item)) || (!read.found && item.Deleted) {
+			continue
+		}
+
+		replicaRPC, err := node.getClient(read.address)
+		if err == nil {
+			var reply string
+			err = replicaRPC.Call("RPCNode.Replicate", &items, &reply)
+			replicaRPC.Close()
+		}
+		if err != nil {
+			node.metrics.readRepairFailures.inc()
+			node.logger.Debug("unable to repair replica", "replica", read.address, "key", key, "err", err)
+			continue
+		}
+
+		read.item, read.found = item, true
+		node.metrics.readRepairs.inc()
+		node.logger.Debug("repaired replica", "replica", read.address, "key", key, "version", item.Version)
+	}
+}
+
+// Replies with the addresses of the replicas of the keys
+// owned by this node, starting with the node itself
+func (node *RPCNode) ReplicaSet(_ *string, replicas *[]string) error {
+	*replicas = append([]string{node.address}, node.replicaTargets()...)
+	return nil
+}
+
+// Replies with the stored Item of a Key, tombstones
+// included, so that replicas can be compared
+func (node *RPCNode) ReadReplica(key *string, item *Item) error {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	stored, ok := node.store.stored(*key)
+	if !ok || stored.expired(time.Now()) {
+		return ErrNoKeyValuePair
+	}
+	*item = stored
+	return nil
+}
+
+// Reads a Key from chord network as per the
+// consistency level of the request
+func (node *RPCNode) Read(req *ReadRequest, result *ReadResult) error {
+	return node.read(req, result)
+}
+
+// GetWithConsistency returns the newest Item of the
+// Key among the replicas which answered, along with
+// the number of replicas which answered
+func (c *Client) GetWithConsistency(key string, consistency Consistency) (*ReadResult, error) {
+	result := new(ReadResult)
+	if err := c.call("RPCNode.Read", &ReadRequest{c.key(key), consistency}, result); err != nil {
+		return result, err
+	}
+	return result, c.openItem(key, &result.Item)
+}
+
+// PutWithConsistency saves the Key-Value pair and
+// returns once enough replicas hold it as per
+// consistency
+func (c *Client) PutWithConsistency(key string, value []byte, consistency Consistency) (*WriteResult, error) {
+	return c.Write(&WriteRequest{Key: key, Value: value, Consistency: consistency})
+}
@@ -0,0 +1,192 @@
+package chord
+
+import (
+	"crypto/tls"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// RateLimit is the sustained rate, in requests per second, and
+// the burst of a token bucket. A zero Rate does not limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Methods clients may call which nodes need to keep the ring
+// in shape. Clients calling them draw from a bucket of their
+// own, see Config.MaintenanceRateLimit, so that they can not
+// starve their other calls nor be starved by them.
+var maintenanceMethods = map[string]bool{
+	"RPCNode.Successor":        true,
+	"RPCNode.FindSuccessor":    true,
+	"RPCNode.Check":            true,
+	"RPCNode.GetId":            true,
+	"RPCNode.Identity":         true,
+	"RPCNode.GetPredecessor":   true,
+	"RPCNode.GetSuccessorList": true,
+}
+
+// tokenBucket holds the tokens left to a client for one
+// method, refilled at the rate of its RateLimit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token from bucket if one is left
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Method of the bucket shared by the maintenance methods
+const maintenanceBucket = "maintenance"
+
+// bucketId identifies the bucket of a client for a method
+type bucketId struct {
+	client string
+	method string
+}
+
+// limiter admits the requests of clients to a node
+type limiter struct {
+	mutex    sync.Mutex
+	buckets  map[bucketId]*tokenBucket
+	inFlight int
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: make(map[bucketId]*tokenBucket)}
+}
+
+// Returns the name clients are limited by, the common name of
+// their certificate if they present one, else their IP address
+func clientName(remoteAddr string, state *tls.ConnectionState) string {
+	if name := certificateName(state); name != "" {
+		return "cn:" + name
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// Returns the bucket method draws from and its limit, that of
+// Config.MethodRateLimits if set, else the bucket shared by the
+// maintenance methods, else Config.ClientRateLimit
+func (node *Node) rateLimit(method string) (string, RateLimit) {
+	if limit, ok := node.config.MethodRateLimits[method]; ok {
+		return method, limit
+	}
+	if method == maintenanceBucket || maintenanceMethods[method] {
+		return maintenanceBucket, node.config.MaintenanceRateLimit
+	}
+	return method, node.config.ClientRateLimit
+}
+
+// Check if a request of client calling method is admitted.
+// Members of the ring always are, and so are the calls proving
+// membership. Admitted client requests count as in flight
+// until release.
+func (node *Node) admit(client, method string, member bool) (admitted, counted bool) {
+	if member || method == "RPCNode.Authenticate" || method == "RPCNode.Unauthorized" || !clientMethods[method] {
+		return true, false
+	}
+
+	l := node.limiter
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if max := node.config.MaxInFlight; max > 0 && l.inFlight >= max {
+		return false, false
+	}
+	if bucket, limit := node.rateLimit(method); limit.Rate > 0 {
+		id := bucketId{client, bucket}
+		tokens, ok := l.buckets[id]
+		if !ok {
+			tokens = new(tokenBucket)
+			l.buckets[id] = tokens
+		}
+		if !tokens.take(limit, time.Now()) {
+			return false, false
+		}
+	}
+	l.inFlight++
+	return true, true
+}
+
+// Marks an admitted client request as done
+func (node *Node) release() {
+	node.limiter.mutex.Lock()
+	node.limiter.inFlight--
+	node.limiter.mutex.Unlock()
+}
+
+// Removes the buckets which have been full for a while,
+// those of clients which stopped calling
+func (node *Node) expireBuckets(now time.Time) {
+	l := node.limiter
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for id, bucket := range l.buckets {
+		_, limit := node.rateLimit(id.method)
+		if limit.Rate <= 0 || now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst)+1 {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// Called before the rpc server looks up the method of a request.
+// Requests which are not admitted are turned into calls to
+// Overloaded.
+func (c *serverCodec) limit(r *rpc.Request) {
+	if c.denied {
+		return
+	}
+	admitted, counted := c.node.admit(c.client, r.ServiceMethod, c.member)
+	if counted {
+		c.mutex.Lock()
+		c.counted[r.Seq] = true
+		c.mutex.Unlock()
+	}
+	if admitted {
+		return
+	}
+
+	c.node.metrics.observeRejection(r.ServiceMethod)
+	c.node.logger.Debug("rejected request over limit", "client", c.client, "method", r.ServiceMethod)
+	r.ServiceMethod = "RPCNode.Overloaded"
+	c.denied = true
+}
+
+// Serves requests which exceeded the limits of the node
+func (node *RPCNode) Overloaded(_ *string, _ *string) error {
+	return ErrOverloaded
+}
+
+// IsOverloaded reports whether err is the rejection of a request
+// by a node over its limits, after which clients should back off
+func IsOverloaded(err error) bool {
+	return err != nil && err.Error() == ErrOverloaded.Error()
+}
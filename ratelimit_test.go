@@ -0,0 +1,75 @@
+package chord
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 3}
+	var bucket tokenBucket
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !bucket.take(limit, now) {
+			t.Fatalf("token %d of the burst refused", i)
+		}
+	}
+	if bucket.take(limit, now) {
+		t.Fatal("token taken beyond the burst")
+	}
+
+	// tokens come back at the rate of the limit
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.take(limit, now) || bucket.take(limit, now) {
+		t.Fatal("refill does not follow the rate")
+	}
+
+	// and never beyond the burst
+	now = now.Add(time.Hour)
+	taken := 0
+	for bucket.take(limit, now) {
+		taken++
+	}
+	if taken != 3 {
+		t.Errorf("took %d tokens after an idle hour", taken)
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	node := newTestNode()
+	node.config.ClientRateLimit = RateLimit{Rate: 1, Burst: 1}
+	node.config.MaintenanceRateLimit = RateLimit{Rate: 1, Burst: 2}
+
+	if admitted, _ := node.admit("client", "RPCNode.Read", false); !admitted {
+		t.Fatal("first read refused")
+	}
+	if admitted, _ := node.admit("client", "RPCNode.Read", false); admitted {
+		t.Fatal("read beyond the burst admitted")
+	}
+
+	// other clients and methods have buckets of their own
+	if admitted, _ := node.admit("other", "RPCNode.Read", false); !admitted {
+		t.Error("read of another client refused")
+	}
+	if admitted, _ := node.admit("client", "RPCNode.Write", false); !admitted {
+		t.Error("write refused after reads")
+	}
+
+	// maintenance methods share the bucket of maintenance
+	for _, method := range []string{"RPCNode.FindSuccessor", "RPCNode.GetId"} {
+		if admitted, _ := node.admit("client", method, false); !admitted {
+			t.Errorf("%s refused", method)
+		}
+	}
+	if admitted, _ := node.admit("client", "RPCNode.Check", false); admitted {
+		t.Error("maintenance call beyond the burst admitted")
+	}
+
+	// members of the ring are never limited
+	for i := 0; i < 10; i++ {
+		if admitted, _ := node.admit("client", "RPCNode.Read", true); !admitted {
+			t.Fatal("member refused")
+		}
+	}
+}
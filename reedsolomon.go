@@ -0,0 +1,214 @@
+package chord
+
+// Arithmetic in GF(2^8) with the polynomial
+// x^8 + x^4 + x^3 + x^2 + 1 (0x11d)
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+
+	// products of every pair of elements
+	gfMulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+// Returns the inverse of a, which must not be 0
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// Returns a raised to the power n
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// Returns the product of matrices a and b
+func matrixMul(a, b [][]byte) [][]byte {
+	product := make([][]byte, len(a))
+	for i := range a {
+		product[i] = make([]byte, len(b[0]))
+		for j := range b[0] {
+			var sum byte
+			for k := range b {
+				sum ^= gfMulTable[a[i][k]][b[k][j]]
+			}
+			product[i][j] = sum
+		}
+	}
+	return product
+}
+
+// Returns the inverse of the square matrix m by
+// Gauss-Jordan elimination, false if it is singular
+func matrixInvert(m [][]byte) ([][]byte, bool) {
+	n := len(m)
+
+	// m augmented with the identity
+	work := make([][]byte, n)
+	for i := range m {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, false
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for j := range work[col] {
+			work[col][j] = gfMulTable[scale][work[col][j]]
+		}
+
+		for i := 0; i < n; i++ {
+			factor := work[i][col]
+			if i == col || factor == 0 {
+				continue
+			}
+			for j := range work[i] {
+				work[i][j] ^= gfMulTable[factor][work[col][j]]
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range work {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, true
+}
+
+// reedSolomon splits values into data fragments
+// and adds parity fragments, so that a value can
+// be rebuilt from any data fragments of them
+type reedSolomon struct {
+	data, parity int
+
+	// rows of the encoding matrix, one per fragment. The
+	// first data rows are the identity so data fragments
+	// hold the value as is.
+	matrix [][]byte
+}
+
+// Returns a coder of data data fragments and
+// parity parity fragments, at most 256 in all
+func newReedSolomon(data, parity int) (*reedSolomon, error) {
+	total := data + parity
+	if data <= 0 || parity < 0 || total > 256 {
+		return nil, ErrInvalidFragments
+	}
+
+	// any data rows of a vandermonde matrix are
+	// invertible, which stays true once its top
+	// is turned into the identity
+	vandermonde := make([][]byte, total)
+	for i := range vandermonde {
+		vandermonde[i] = make([]byte, data)
+		for j := range vandermonde[i] {
+			vandermonde[i][j] = gfPow(byte(i), j)
+		}
+	}
+	top, _ := matrixInvert(vandermonde[:data])
+
+	return &reedSolomon{data, parity, matrixMul(vandermonde, top)}, nil
+}
+
+// Splits value into data fragments padded
+// with zeros followed by parity fragments
+func (rs *reedSolomon) encode(value []byte) [][]byte {
+	size := (len(value) + rs.data - 1) / rs.data
+	if size == 0 {
+		size = 1
+	}
+
+	padded := make([]byte, size*rs.data)
+	copy(padded, value)
+
+	fragments := make([][]byte, rs.data+rs.parity)
+	for i := 0; i < rs.data; i++ {
+		fragments[i] = padded[i*size : (i+1)*size]
+	}
+	for i := rs.data; i < len(fragments); i++ {
+		fragments[i] = rs.combine(rs.matrix[i], fragments[:rs.data], size)
+	}
+	return fragments
+}
+
+// Rebuilds a value of given size from fragments, in
+// which missing fragments are nil. At least data
+// fragments must be present.
+func (rs *reedSolomon) decode(fragments [][]byte, size int) ([]byte, error) {
+	rows := make([][]byte, 0, rs.data)
+	present := make([][]byte, 0, rs.data)
+	for i, fragment := range fragments {
+		if fragment != nil && len(rows) < rs.data {
+			rows = append(rows, rs.matrix[i])
+			present = append(present, fragment)
+		}
+	}
+	if len(rows) < rs.data {
+		return nil, ErrNotEnoughFragments
+	}
+
+	decoder, ok := matrixInvert(rows)
+	if !ok {
+		return nil, ErrNotEnoughFragments
+	}
+
+	length := len(present[0])
+	value := make([]byte, 0, length*rs.data)
+	for i := 0; i < rs.data; i++ {
+		if fragments[i] != nil {
+			value = append(value, fragments[i]...)
+		} else {
+			value = append(value, rs.combine(decoder[i], present, length)...)
+		}
+	}
+
+	if size > len(value) {
+		return nil, ErrNotEnoughFragments
+	}
+	return value[:size], nil
+}
+
+// Returns the fragment whose bytes are the sums of
+// the bytes of fragments multiplied by coefficients
+func (rs *reedSolomon) combine(coefficients []byte, fragments [][]byte, size int) []byte {
+	combined := make([]byte, size)
+	for j, fragment := range fragments {
+		row := &gfMulTable[coefficients[j]]
+		for b := range combined {
+			combined[b] ^= row[fragment[b]]
+		}
+	}
+	return combined
+}
@@ -0,0 +1,48 @@
+package chord
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReedSolomonRebuildsFromAnyDataFragments(t *testing.T) {
+	rs, err := newReedSolomon(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("a value which does not split evenly into four")
+	fragments := rs.encode(value)
+	if len(fragments) != 6 {
+		t.Fatalf("encoded into %d fragments", len(fragments))
+	}
+
+	// every choice of two missing fragments
+	for i := 0; i < len(fragments); i++ {
+		for j := i + 1; j < len(fragments); j++ {
+			present := append([][]byte{}, fragments...)
+			present[i], present[j] = nil, nil
+			decoded, err := rs.decode(present, len(value))
+			if err != nil || !bytes.Equal(decoded, value) {
+				t.Errorf("without fragments %d and %d: %q, %v", i, j, decoded, err)
+			}
+		}
+	}
+}
+
+func TestReedSolomonNeedsDataFragments(t *testing.T) {
+	rs, _ := newReedSolomon(4, 2)
+	value := []byte("value")
+	fragments := rs.encode(value)
+	fragments[0], fragments[2], fragments[5] = nil, nil, nil
+	if _, err := rs.decode(fragments, len(value)); err != ErrNotEnoughFragments {
+		t.Fatalf("decoded from 3 of 4 data fragments: %v", err)
+	}
+}
+
+func TestReedSolomonRejectsInvalidCounts(t *testing.T) {
+	for _, counts := range [][2]int{{0, 2}, {4, -1}, {200, 57}} {
+		if _, err := newReedSolomon(counts[0], counts[1]); err != ErrInvalidFragments {
+			t.Errorf("%d data and %d parity fragments: %v", counts[0], counts[1], err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package chord
+
+import "net/rpc"
+
+// Returns the addresses of the nodes which hold copies
+// of the keys owned by this node i.e. the first
+// Config.ReplicationFactor-1 nodes of its successor list
+func (node *Node) replicaTargets() []string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	targets := make([]string, 0, node.config.ReplicationFactor-1)
+	for _, successor := range node.successorList {
+		if len(targets) >= node.config.ReplicationFactor-1 {
+			break
+		}
+		if successor.address != node.address {
+			targets = append(targets, successor.address)
+		}
+	}
+	return targets
+}
+
+// Returns the stored Items of keys, tombstones
+// included. node.mutex must be held by the caller.
+func (node *Node) itemsOf(keys ...string) []TransferItem {
+	items := make([]TransferItem, 0, len(keys))
+	for _, key := range keys {
+		if item, ok := node.store.stored(key); ok {
+			items = append(items, TransferItem{key, item})
+		}
+	}
+	return items
+}
+
+// Sends items to the replicas of this node in parallel and
+// returns the number of nodes holding them, this node included,
+// once enough of them do as per consistency. Returns
+// ErrNotEnoughReplicas if too few replicas could be reached,
+// those are repaired later by anti-entropy.
+func (node *Node) replicate(items []TransferItem, consistency Consistency) (int, error) {
+	targets := node.replicaTargets()
+	need := consistency.required(len(targets) + 1)
+	if len(items) == 0 || len(targets) == 0 {
+		return 1, nil
+	}
+
+	acks := make(chan bool, len(targets))
+	for _, replica := range targets {
+		go func(replica string) {
+			replicaRPC, err := node.getClient(replica)
+			if err == nil {
+				err = node.replicateTo(replicaRPC, items)
+				replicaRPC.Close()
+			}
+			if err != nil {
+				node.logger.Debug("unable to replicate keys", "to", replica, "err", err)
+
+				// replayed once the replica is back
+				node.mutex.Lock()
+				node.addHint(replica, items, false)
+				node.mutex.Unlock()
+			}
+			acks <- err == nil
+		}(replica)
+	}
+
+	// the remaining replicas are written to
+	// in the background once enough acked
+	replicas := 1
+	for i := 0; i < len(targets) && replicas < need; i++ {
+		if <-acks {
+			replicas++
+		}
+	}
+	if replicas < need {
+		return replicas, ErrNotEnoughReplicas
+	}
+	return replicas, nil
+}
+
+// Sends items to a replica in batches of about
+// Config.TransferChunkSize bytes, so that each fits
+// within the message limit of the replica
+func (node *Node) replicateTo(replicaRPC *rpc.Client, items []TransferItem) error {
+	for len(items) > 0 {
+		end, size := 0, 0
+		for end < len(items) && (end == 0 || size+items[end].size() <= node.config.TransferChunkSize) {
+			size += items[end].size()
+			end++
+		}
+		batch := items[:end]
+
+		var reply string
+		if err := replicaRPC.Call("RPCNode.Replicate", &batch, &reply); err != nil {
+			return err
+		}
+		items = items[end:]
+	}
+	return nil
+}
+
+// Saves Items replicated from the node owning them.
+// Items not newer than the ones stored are ignored.
+func (node *RPCNode) Replicate(items *[]TransferItem, _ *string) error {
+	node.mutex.Lock()
+	for _, ti := range *items {
+		node.store.merge(ti.Key, ti.Item)
+	}
+	node.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,263 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Nodes record themselves in connections.db, which
+// is restored once the tests have run
+func TestMain(m *testing.M) {
+	_, file, _, _ := runtime.Caller(0)
+	dbPath := filepath.Join(filepath.Dir(file), "connections.db")
+	db, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	if ring != nil {
+		ring.stop()
+	}
+	if err = ioutil.WriteFile(dbPath, db, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+// testRing is a ring of nodes started in process
+type testRing struct {
+	ca    *EphemeralCA
+	nodes []*RPCNode
+}
+
+// ring shared by the tests which only read and write
+// keys, started by sharedRing on first use
+var ring *testRing
+
+// Namespaces of the shared ring. The certificates
+// of clients issued by the CA are of "chord client".
+var ringNamespaces = map[string]Namespace{
+	"billing": {Readers: []string{"*"}, Writers: []string{"billing-app"}},
+	"quota":   {Readers: []string{"*"}, Writers: []string{"*"}, Admins: []string{"chord client"}, MaxKeys: 1},
+}
+
+// Returns the shared ring, three nodes serving mutual
+// TLS with certificates of an ephemeral CA, trusting
+// each other by their certificates
+func sharedRing(t *testing.T) *testRing {
+	if ring != nil {
+		return ring
+	}
+	ca, err := NewEphemeralCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring = startRing(t, ca, 3, func(config *Config) {
+		config.CertificateAuth = true
+		config.Namespaces = ringNamespaces
+	})
+	return ring
+}
+
+// Returns an address on the loopback
+// interface which nothing listens on
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// Returns the config of a node at address which
+// joins join, logging errors only
+func testConfig(address, join string) *Config {
+	config := DefaultConfig(address, join)
+	config.Logger = NewLogger(io.Discard, LevelError)
+	return config
+}
+
+// Returns a node which is neither started nor part of a
+// ring, for tests of what a node does on its own
+func newTestNode() *RPCNode {
+	config := testConfig("127.0.0.1:0", "").withDefaults()
+	id := getHash(config.Address)
+	node := &RPCNode{
+		Node: &Node{
+			id:        id,
+			config:    config,
+			logger:    newNodeLogger(config.Logger, config.Address, id),
+			address:   config.Address,
+			store:     newDataStore(),
+			transfers: make(map[string]TransferInfo),
+			incoming:  make(map[string]*incomingTransfer),
+			hints:     make(map[string]map[string]hint),
+			fragments: make(map[fragmentId]storedFragment),
+			usages:    make(map[string]Usage),
+			limiter:   newLimiter(),
+			metrics:   newMetrics(),
+		},
+	}
+	node.store.changed = node.countUsage
+	return node
+}
+
+// Starts n nodes serving mutual TLS with certificates of
+// ca and waits until they form a ring. configure, if not
+// nil, is called with the config of every node.
+func startRing(t *testing.T, ca *EphemeralCA, n int, configure func(*Config)) *testRing {
+	r := &testRing{ca: ca}
+	join := ""
+	for i := 0; i < n; i++ {
+		config := testConfig(freeAddress(t), join)
+		tlsConfig, err := ca.TLSConfig(true, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.TLS = tlsConfig
+		if configure != nil {
+			configure(config)
+		}
+
+		node, err := CreateNewNodeWithConfig(config)
+		if err != nil {
+			r.stop()
+			t.Fatal(err)
+		}
+		r.nodes = append(r.nodes, node)
+		join = node.address
+	}
+	r.wait(t)
+	return r
+}
+
+// Waits until the successor and predecessor of
+// every node are its neighbours by id
+func (r *testRing) wait(t *testing.T) {
+	sorted := append([]*RPCNode{}, r.nodes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].id, sorted[j].id) == -1
+	})
+
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		formed := true
+		for i, node := range sorted {
+			next := sorted[(i+1)%len(sorted)]
+			prev := sorted[(i+len(sorted)-1)%len(sorted)]
+			node.mutex.RLock()
+			formed = formed && node.fingerTable[0].address == next.address &&
+				(len(sorted) == 1 || node.predecessorAddr == prev.address)
+			node.mutex.RUnlock()
+		}
+		if formed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nodes did not form a ring")
+		}
+	}
+}
+
+// Stops every node of the ring
+func (r *testRing) stop() {
+	for _, node := range r.nodes {
+		node.Stop()
+	}
+}
+
+// Returns a client of the ring with a client
+// certificate issued by the CA of the ring
+func (r *testRing) client(t *testing.T) *Client {
+	return r.clientOf(t, r.nodes[0].address)
+}
+
+// Same as client but connects to the node at address
+func (r *testRing) clientOf(t *testing.T, address string) *Client {
+	tlsConfig, err := r.ca.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := DialTLS(address, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Returns the node of the ring at address
+func (r *testRing) node(address string) *RPCNode {
+	for _, node := range r.nodes {
+		if node.address == address {
+			return node
+		}
+	}
+	return nil
+}
+
+func TestRingStoresKeysAtOwner(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("ring-", i)
+		address, err := client.Put(key, []byte(key))
+		if err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+
+		lookup, err := client.Lookup(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lookup.Address != address {
+			t.Errorf("%s stored on %s, owned by %s", key, address, lookup.Address)
+		}
+
+		owner := r.node(address)
+		owner.mutex.RLock()
+		item, ok := owner.store.get(key)
+		owner.mutex.RUnlock()
+		if !ok || string(item.Value) != key {
+			t.Errorf("%s missing from its owner %s", key, address)
+		}
+
+		value, err := client.Get(key)
+		if err != nil || string(value) != key {
+			t.Errorf("get %s = %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestCompareAndSwapReturnsVersionMismatch(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	version, err := client.PutIfAbsent("cas", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.PutIfAbsent("cas", []byte("again")); err != ErrVersionMismatch {
+		t.Errorf("put of existing key: %v", err)
+	}
+	if _, err = client.CompareAndSwap("cas", version+1, []byte("second")); err != ErrVersionMismatch {
+		t.Errorf("swap of another version: %v", err)
+	}
+	if _, err = client.CompareAndSwap("cas", version, []byte("second")); err != nil {
+		t.Errorf("swap of current version: %v", err)
+	}
+}
@@ -1,8 +1,6 @@
 package chord
 
-import (
-	"fmt"
-)
+import "time"
 
 // This structure houses rpc methods of Node
 type RPCNode struct {
@@ -14,49 +12,31 @@ type RPCNode struct {
 // Successor node of id N is the first node whose id is
 // either equal to N or follows N (in clockwise fashnion).
 func (node *RPCNode) Successor(id []byte, rpcAddr *string) error {
+	var lookup Lookup
+	err := node.findSuccessor(id, &lookup)
+	*rpcAddr = lookup.Address
+	return err
+}
 
-	// If the id is between node and its successor
-	// then return the successor
-	node.mutex.RLock()
-	if betweenRightInc(id, node.id, node.fingerTable[0].id) {
-		*rpcAddr = node.fingerTable[0].address
-		node.mutex.RUnlock()
-		return nil
-	}
-	node.mutex.RUnlock()
-
-	// find the closest preceeding node for given id
-	pred, address := node.closest_preceeding_node(id)
-	var predId []byte
-	pred.Call("RPCNode.GetId", "", &predId)
-	defer pred.Close()
-
-	node.mutex.RLock()
-	if equal(node.id, predId) {
-		// If the closest preceeding node and
-		// current node are same, return the
-		// address of closest preceeding node
-		*rpcAddr = address
-		node.mutex.RUnlock()
-		return nil
-	} else {
-		node.mutex.RUnlock()
-		// If they are different, call Successor function
-		// on closest preceeding node and return its result
-		var newAddress string
-		pred.Call("RPCNode.Successor", id, &newAddress)
-		*rpcAddr = newAddress
-		return nil
-	}
+// FindSuccessor is same as Successor but also
+// returns the path taken by the lookup
+func (node *RPCNode) FindSuccessor(id []byte, lookup *Lookup) error {
+	return node.findSuccessor(id, lookup)
 }
 
-// Check if node pointed by predAddr is the correct/best predecessor
-func (node *RPCNode) Notify(predAddr *string, _ *string) error {
+// Check if node which sent the announcement
+// is the correct/best predecessor
+func (node *RPCNode) Notify(ann *Announcement, _ *string) error {
+	predAddr := &ann.Address
+
 	// get rpc client
-	predRPC, _ := getClient(*predAddr)
+	predRPC, _ := node.getClient(*predAddr)
 
-	var predId []byte
-	predRPC.Call("RPCNode.GetId", "", &predId)
+	predId, err := node.announcedId(predRPC, ann)
+	if err != nil && node.config.Identity != nil {
+		predRPC.Close()
+		return err
+	}
 
 	if node.predecessorId == nil || between(predId, node.predecessorId, node.id) {
 		// if our predecessor is nil or if node pointed by predId
@@ -74,6 +54,8 @@ func (node *RPCNode) Notify(predAddr *string, _ *string) error {
 		node.predecessorRPC = predRPC
 		node.predecessorId = predId
 		node.predecessorAddr = *predAddr
+		node.predecessorUpdated = time.Now()
+		node.recountUsage()
 		node.mutex.Unlock()
 	}
 	return nil
@@ -106,12 +88,31 @@ func (node *RPCNode) GetPredecessor(_ *string, reply *string) error {
 
 // Saves data into node's store
 func (node *RPCNode) SetData(data *map[string][]byte, _ *string) error {
-	fmt.Println("Setting [")
+	node.mutex.Lock()
+	growth := 0
+	for key, value := range *data {
+		req := &WriteRequest{Key: key, Value: value}
+		if err := node.checkSize(req); err != nil {
+			node.mutex.Unlock()
+			return err
+		}
+		growth += node.growth(req)
+	}
+	if err := node.checkCapacity(growth); err != nil {
+		node.mutex.Unlock()
+		return err
+	}
+
+	keys := make([]string, 0, len(*data))
 	for key, value := range *data {
-		fmt.Println(key, ":", value, ",")
-		node.store.set(key, value)
+		node.logger.Debug("setting key", "key", key, "value", node.redact(value))
+		node.store.set(key, value, time.Time{})
+		keys = append(keys, key)
 	}
-	fmt.Println("]")
+	items := node.itemsOf(keys...)
+	node.mutex.Unlock()
+
+	node.replicate(items, ConsistencyOne)
 	return nil
 }
 
@@ -119,14 +120,50 @@ func (node *RPCNode) SetData(data *map[string][]byte, _ *string) error {
 // if the node has the pair in its store else returns
 // an error
 func (node *RPCNode) GetValue(key *string, value *[]byte) error {
+	var item Item
+	err := node.GetItem(key, &item)
+	*value = item.Value
+	return err
+}
+
+// Same as GetValue but returns the Value
+// along with its version
+func (node *RPCNode) GetItem(key *string, item *Item) error {
 	var ok bool
-	*value, ok = node.store.get(*key)
+	node.mutex.RLock()
+	*item, ok = node.store.get(*key)
+	node.mutex.RUnlock()
 	if !ok {
 		return ErrNoKeyValuePair
 	}
+	if item.Erasure != nil {
+		return node.rebuild(*key, item)
+	}
 	return nil
 }
 
+// Applies a write to node's store. Conditional
+// writes are checked and applied atomically. The
+// write is then copied to the replicas, it stays
+// applied even if too few replicas acknowledge it.
+func (node *RPCNode) ApplyWrite(req *WriteRequest, result *WriteResult) error {
+	if req.Consistency == ConsistencyLinearizable {
+		return node.chainWrite(req, result)
+	}
+
+	node.mutex.Lock()
+	version, err := node.applyWrite(req)
+	items := node.itemsOf(req.Key)
+	node.mutex.Unlock()
+
+	result.Version = version
+	if err != nil {
+		return err
+	}
+	result.Replicas, err = node.replicate(items, req.Consistency)
+	return err
+}
+
 // manually set successor of node
 func (node *RPCNode) SetSuccessor(successorAddr *string, _ *string) error {
 	// If successorAddr is same our address
@@ -135,8 +172,9 @@ func (node *RPCNode) SetSuccessor(successorAddr *string, _ *string) error {
 		node.mutex.Lock()
 		node.fingerTable[0].address = node.address
 		node.fingerTable[0].id = node.id
+		node.fingerTable[0].updated = time.Now()
 
-		go updateSuccessor(node.db, node.address, node.address)
+		go updateSuccessor(node.db, node.logger, node.address, node.address)
 		node.mutex.Unlock()
 		return nil
 	}
@@ -144,17 +182,20 @@ func (node *RPCNode) SetSuccessor(successorAddr *string, _ *string) error {
 	// Update successor details in accordance to
 	// the new successor
 
-	successorRPC, _ := getClient(*successorAddr)
+	successorRPC, _ := node.getClient(*successorAddr)
 	defer successorRPC.Close()
 
-	var successorId []byte
-	successorRPC.Call("RPCNode.GetId", "", &successorId)
+	successorId, err := node.peerId(successorRPC, *successorAddr)
+	if err != nil && node.config.Identity != nil {
+		return err
+	}
 
 	node.mutex.Lock()
 	node.fingerTable[0].id = successorId
 	node.fingerTable[0].address = *successorAddr
+	node.fingerTable[0].updated = time.Now()
 
-	go updateSuccessor(node.db, node.address, *successorAddr)
+	go updateSuccessor(node.db, node.logger, node.address, *successorAddr)
 
 	node.mutex.Unlock()
 
@@ -172,10 +213,13 @@ func (node *RPCNode) SetPredecessor(predAddr *string, _ *string) error {
 
 	// Update predecessor details in accordance
 	// to the new predecessor
-	predRPC, _ := getClient(*predAddr)
+	predRPC, _ := node.getClient(*predAddr)
 
-	var predId []byte
-	predRPC.Call("RPCNode.GetId", "", &predId)
+	predId, err := node.peerId(predRPC, *predAddr)
+	if err != nil && node.config.Identity != nil {
+		predRPC.Close()
+		return err
+	}
 
 	node.makePredecessorNil()
 
@@ -183,6 +227,8 @@ func (node *RPCNode) SetPredecessor(predAddr *string, _ *string) error {
 	node.predecessorId = predId
 	node.predecessorRPC = predRPC
 	node.predecessorAddr = *predAddr
+	node.predecessorUpdated = time.Now()
+	node.recountUsage()
 	node.mutex.Unlock()
 	return nil
 }
@@ -191,29 +237,52 @@ func (node *RPCNode) SetPredecessor(predAddr *string, _ *string) error {
 func (node *RPCNode) Retrieve(key *string, value *[]byte) error {
 
 	// Find where the Key is stored
-	var getNodeAddr string
-	node.self.Call("RPCNode.Successor", getHash(*key), &getNodeAddr)
+	getNodeAddr := node.lookup(getHash(*key))
 
-	getNode, _ := getClient(getNodeAddr)
+	getNode, err := node.getClient(getNodeAddr)
+	if err != nil {
+		return err
+	}
 	defer getNode.Close()
 
 	// Get the Value corresponding to the Key
 	// from the node which stores the Key
-	var val []byte
-	err := getNode.Call("RPCNode.GetValue", &key, &val)
+	return getNode.Call("RPCNode.GetValue", key, value)
+}
+
+// Same as Retrieve but returns the Value
+// along with its version
+func (node *RPCNode) RetrieveItem(key *string, item *Item) error {
+	getNodeAddr := node.lookup(getHash(*key))
 
-	// make val equal to error string if there
-	// is an error in getting the Value
+	getNode, err := node.getClient(getNodeAddr)
 	if err != nil {
-		val = []byte(err.Error())
+		return err
 	}
+	defer getNode.Close()
 
-	// set the Value variable
-	*value = val
-	return nil
+	return getNode.Call("RPCNode.GetItem", key, item)
+}
+
+// Writes to a Key at the node which owns it. Conditional
+// writes fail with ErrVersionMismatch if the Key's version
+// does not match the expected version.
+func (node *RPCNode) Write(req *WriteRequest, result *WriteResult) error {
+	return node.write(req, result)
 }
 
+// Saves a Key-Value pair in chord network and
+// returns address of node where it is stored
 func (node *RPCNode) Save(e KeyValue, storeNode *string) error {
-	*storeNode = node.save(e.Key, e.Value)
-	return nil
+	var err error
+	*storeNode, err = node.save(e.Key, e.Value)
+	return err
+}
+
+// Deletes a Key-Value pair from chord network and
+// returns address of node where it was stored
+func (node *RPCNode) Delete(key *string, storeNode *string) error {
+	var err error
+	*storeNode, err = node.delete(*key)
+	return err
 }
@@ -0,0 +1,249 @@
+package chord
+
+import (
+	"math/big"
+	"sort"
+)
+
+// Number of lookups run by SecureLookup when
+// Config.SecureLookups does not set it
+const defaultSecureLookups = 3
+
+// SecureLookup is the outcome of resolving the
+// successor of an id by redundant lookups
+type SecureLookup struct {
+	// successor chosen among the answers
+	Lookup
+
+	// every lookup run, the first entry is the answer of the
+	// node's own successor list if the id lies within it
+	Lookups []Lookup
+
+	// the successor of every lookup could be verified,
+	// and they all agreed
+	Agreed bool
+
+	// addresses of the hops which answered with a successor
+	// other than the one chosen, or one which failed to verify
+	Suspicious []string
+}
+
+// Returns the successor of id as known from the successor
+// list, false if id does not lie within the list
+func (node *Node) listedSuccessor(id []byte) (Lookup, bool) {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	previous := node.id
+	for _, successor := range node.successorList {
+		if betweenRightInc(id, previous, successor.id) {
+			return Lookup{Address: successor.address, Id: successor.id, Path: []string{node.address}}, true
+		}
+		previous = successor.id
+	}
+	return Lookup{}, false
+}
+
+// Returns the addresses of up to count distinct fingers to
+// start lookups of id from, those closest preceding id first
+func (node *Node) lookupStarts(id []byte, count int) []string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	seen := map[string]bool{node.address: true}
+	starts := make([]string, 0, count)
+	add := func(address string) {
+		if !seen[address] && len(starts) < count {
+			seen[address] = true
+			starts = append(starts, address)
+		}
+	}
+
+	for i := len(node.fingerTable) - 1; i >= 0; i-- {
+		if finger := node.fingerTable[i]; finger != nil && between(finger.id, node.id, id) {
+			add(finger.address)
+		}
+	}
+
+	// routes from fingers past id go round the
+	// ring, which is slower but still independent
+	for _, finger := range node.fingerTable {
+		if finger != nil {
+			add(finger.address)
+		}
+	}
+	for _, successor := range node.successorList {
+		add(successor.address)
+	}
+	return starts
+}
+
+// Check if the node found by lookup is the successor of
+// id, that is id lies between its predecessor and it.
+// The ids of both are verified with the nodes themselves.
+func (node *Node) verifySuccessor(id []byte, lookup Lookup) bool {
+	if lookup.Address == node.address {
+		predId := node.predecessorIdOf()
+		return predId != nil && betweenRightInc(id, predId, node.id)
+	}
+
+	successorRPC, err := node.getClient(lookup.Address)
+	if err != nil {
+		return false
+	}
+	defer successorRPC.Close()
+
+	successorId, err := node.peerId(successorRPC, lookup.Address)
+	if err != nil || !equal(successorId, lookup.Id) {
+		return false
+	}
+
+	var predAddr string
+	if err = successorRPC.Call("RPCNode.GetPredecessor", "", &predAddr); err != nil {
+		return false
+	}
+	if predAddr == node.address {
+		return betweenRightInc(id, node.id, successorId)
+	}
+
+	predRPC, err := node.getClient(predAddr)
+	if err != nil {
+		return false
+	}
+	defer predRPC.Close()
+
+	predId, err := node.peerId(predRPC, predAddr)
+	if err != nil {
+		return false
+	}
+	return betweenRightInc(id, predId, successorId)
+}
+
+// Returns the id of the node's predecessor
+func (node *Node) predecessorIdOf() []byte {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.predecessorId
+}
+
+// Resolves the successor of id by lookups started from count
+// different fingers. The answers are checked against each other,
+// against the successor list and against the predecessors of
+// the nodes found. Of the answers which verify, the one closest
+// to id is chosen, as a node skipping the true successor can
+// only answer with a node further from id. Fails with
+// ErrFailedToReach if no lookup reached a node.
+func (node *Node) secureLookup(id []byte, count int) (SecureLookup, error) {
+	var result SecureLookup
+	if listed, ok := node.listedSuccessor(id); ok {
+		result.Lookups = append(result.Lookups, listed)
+	}
+
+	starts := node.lookupStarts(id, count)
+	lookups := make(chan Lookup, len(starts))
+	for _, start := range starts {
+		go func(start string) {
+			lookup := Lookup{Path: []string{node.address}}
+			startRPC, err := node.getClient(start)
+			if err == nil {
+				var next Lookup
+				if err = startRPC.Call("RPCNode.FindSuccessor", id, &next); err == nil {
+					lookup.Address, lookup.Id = next.Address, next.Id
+					lookup.Path = append(lookup.Path, next.Path...)
+				}
+				startRPC.Close()
+			}
+			if err != nil {
+				lookup.Path = append(lookup.Path, start)
+			}
+			lookups <- lookup
+		}(start)
+	}
+	for range starts {
+		result.Lookups = append(result.Lookups, <-lookups)
+	}
+
+	// a lone node has no one to ask
+	if len(result.Lookups) == 0 {
+		node.findSuccessor(id, &result.Lookup)
+		result.Lookups = []Lookup{result.Lookup}
+		result.Agreed = true
+		return result, nil
+	}
+
+	// answers ordered by their distance from id
+	distance := func(lookup Lookup) *big.Int {
+		d := new(big.Int).Sub(toBigInt(lookup.Id), toBigInt(id))
+		return d.Mod(d, new(big.Int).Lsh(big.NewInt(1), 160))
+	}
+	answers := make([]Lookup, 0, len(result.Lookups))
+	for _, lookup := range result.Lookups {
+		if lookup.Address != "" {
+			answers = append(answers, lookup)
+		}
+	}
+	sort.SliceStable(answers, func(i, j int) bool {
+		return distance(answers[i]).Cmp(distance(answers[j])) < 0
+	})
+
+	verified := make(map[string]bool)
+	for _, answer := range answers {
+		if _, ok := verified[answer.Address]; !ok {
+			verified[answer.Address] = node.verifySuccessor(id, answer)
+		}
+		if verified[answer.Address] && result.Address == "" {
+			result.Lookup = answer
+		}
+	}
+	if len(answers) == 0 {
+		node.logger.Warn("no lookup reached a node", "id", ID(id))
+		return result, ErrFailedToReach
+	}
+	if result.Address == "" {
+		// nothing verified, fall back to the closest answer
+		result.Lookup = answers[0]
+	}
+
+	// the last hop of a lookup is the node which named the
+	// successor it ended with. Lookups which failed to reach
+	// a node are left out.
+	result.Agreed = true
+	for _, lookup := range result.Lookups {
+		if lookup.Address == "" || (lookup.Address == result.Address && verified[lookup.Address]) {
+			continue
+		}
+		result.Agreed = false
+		if hop := lookup.Path[len(lookup.Path)-1]; hop != node.address {
+			result.Suspicious = append(result.Suspicious, hop)
+		}
+	}
+
+	node.metrics.secureLookups.inc()
+	if !result.Agreed {
+		node.metrics.suspiciousLookups.inc()
+		node.logger.Warn("lookups disagree", "id", ID(id), "successor", result.Address, "suspicious", result.Suspicious)
+	}
+	return result, nil
+}
+
+// Finds the successor of id by redundant lookups. The number
+// of lookups is Config.SecureLookups, or 3 if it is not set.
+func (node *RPCNode) SecureFindSuccessor(id []byte, result *SecureLookup) error {
+	count := node.config.SecureLookups
+	if count <= 1 {
+		count = defaultSecureLookups
+	}
+	var err error
+	*result, err = node.secureLookup(id, count)
+	return err
+}
+
+// SecureLookup finds the node storing the Key by redundant
+// lookups and reports the hops which disagreed
+func (c *Client) SecureLookup(key string) (*SecureLookup, error) {
+	result := new(SecureLookup)
+	if err := c.call("RPCNode.SecureFindSuccessor", getHash(c.key(key)), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,26 @@
+package chord
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSecureLookupFailsWithoutAnswers(t *testing.T) {
+	node := newTestNode()
+	node.config.SecureLookups = 3
+	dead := freeAddress(t)
+	node.fingerTable = []*Finger{{getHash(dead), dead, time.Now()}}
+
+	if _, err := node.secureLookup(getHash("key"), 3); err != ErrFailedToReach {
+		t.Errorf("lookup through unreachable fingers: %v", err)
+	}
+
+	// writes fail rather than being handed off to no one
+	var result WriteResult
+	if err := node.write(&WriteRequest{Key: "key", Value: []byte("value")}, &result); err != ErrFailedToReach {
+		t.Errorf("write through unreachable fingers: %v", err)
+	}
+	if _, err := node.nextLive(Lookup{}); err != ErrFailedToReach {
+		t.Errorf("next live node of an empty lookup: %v", err)
+	}
+}
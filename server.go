@@ -0,0 +1,263 @@
+package chord
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// rpcHandler serves the rpc methods of a node over
+// HTTP CONNECT in the same way as rpc.Server.ServeHTTP
+// but wraps every connection in a serverCodec so that
+// individual calls can be observed.
+type rpcHandler struct {
+	node   *Node
+	server *rpc.Server
+}
+
+// Reply sent on successful HTTP CONNECT, this must match
+// the one net/rpc clients expect.
+const connected = "200 Connected to Go RPC"
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	codec := newServerCodec(conn, h.node)
+	codec.client = clientName(req.RemoteAddr, req.TLS)
+	codec.principal = certificateName(req.TLS)
+
+	// connections of nodes prove to be members of the ring by
+	// their certificate or by answering a challenge
+	codec.member = h.node.config.CertificateAuth && memberCertificate(req.TLS)
+	header := ""
+	if h.node.config.ClusterKey != nil && !codec.member {
+		if codec.challenge, err = newChallenge(); err != nil {
+			conn.Close()
+			return
+		}
+		header = challengeHeader + ": " + hex.EncodeToString(codec.challenge) + "\n"
+	}
+
+	io.WriteString(conn, "HTTP/1.0 "+connected+"\n"+header+"\n")
+	h.server.ServeCodec(codec)
+}
+
+// serverCodec is a gob rpc.ServerCodec which records
+// the time taken to serve each request.
+type serverCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	node   *Node
+	closed bool
+
+	// the peer is a member of the ring and may call internal
+	// methods, see auth.go. challenge is the one sent to it.
+	member    bool
+	challenge []byte
+
+	// the request being read is a call to Authenticate,
+	// or a call to an internal method which is denied
+	authenticating bool
+	denied         bool
+
+	// name the peer is rate limited by, see ratelimit.go, and
+	// the name it is granted access to namespaces by, see
+	// namespace.go. method is that of the request being read.
+	client    string
+	principal string
+	method    string
+
+	// time at which each pending request was read, and
+	// whether it counts as in flight, keyed by sequence number
+	mutex   sync.Mutex
+	started map[uint64]time.Time
+	counted map[uint64]bool
+}
+
+func newServerCodec(conn io.ReadWriteCloser, node *Node) *serverCodec {
+	buf := bufio.NewWriter(conn)
+	return &serverCodec{
+		rwc:     conn,
+		dec:     gob.NewDecoder(&messageReader{r: bufio.NewReader(conn), max: node.messageLimit()}),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		node:    node,
+		started: make(map[uint64]time.Time),
+		counted: make(map[uint64]bool),
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	c.authorize(r)
+	c.limit(r)
+	c.method = r.ServiceMethod
+	c.mutex.Lock()
+	c.started[r.Seq] = time.Now()
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	if c.denied {
+		// arguments of a denied or rejected call are discarded
+		c.denied = false
+		return c.dec.DecodeValue(reflect.Value{})
+	}
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	if c.authenticating {
+		c.authenticate(body)
+		return nil
+	}
+	c.clearInternal(body)
+	// an error fails the call without serving it
+	return c.checkNamespaces(body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	c.mutex.Lock()
+	start, ok := c.started[r.Seq]
+	counted := c.counted[r.Seq]
+	delete(c.started, r.Seq)
+	delete(c.counted, r.Seq)
+	c.mutex.Unlock()
+	if ok {
+		c.node.metrics.observeRPC(r.ServiceMethod, time.Since(start))
+	}
+	if counted {
+		c.node.release()
+	}
+
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Gob couldn't encode the header. Should not happen, so if it does,
+			// shut down the connection to signal that the connection is broken.
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// Was a gob problem encoding the body but the header has been written.
+			// Shut down the connection to signal that the connection is broken.
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		// Only call c.rwc.Close once; otherwise the semantics are undefined.
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// messageReader passes the gob messages read from r through.
+// Messages longer than max are skipped without being held in
+// memory and fail with ErrMessageTooLarge, which fails the call
+// they carry while the connection goes on. gob itself accepts
+// messages of up to a GiB and allocates them whole before
+// decoding.
+type messageReader struct {
+	r   *bufio.Reader
+	max int
+
+	// bytes left of the message being read,
+	// its length prefix included
+	remaining int
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.remaining == 0 {
+		size, err := m.next()
+		if err == ErrMessageTooLarge && size > 0 {
+			// the messages after it are read as usual
+			if _, discardErr := m.r.Discard(size); discardErr != nil {
+				return 0, discardErr
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		m.remaining = size
+	}
+	if len(p) > m.remaining {
+		p = p[:m.remaining]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= n
+	return n, err
+}
+
+// Returns the size of the next message along with its length
+// prefix, which gob encodes as a single byte below 128 or else
+// as the negated count of the big endian bytes that follow. The
+// size of a message longer than max is returned along with
+// ErrMessageTooLarge, or 0 if it is too large to be skipped.
+func (m *messageReader) next() (int, error) {
+	prefix, err := m.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	if prefix[0] < 0x80 {
+		return 1 + int(prefix[0]), nil
+	}
+
+	count := -int(int8(prefix[0]))
+	if count > 8 {
+		return 0, ErrMessageTooLarge
+	}
+	if prefix, err = m.r.Peek(1 + count); err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, b := range prefix[1:] {
+		size = size<<8 | uint64(b)
+	}
+	if size > uint64(m.max) {
+		if size > math.MaxInt32 {
+			return 0, ErrMessageTooLarge
+		}
+		return 1 + count + int(size), ErrMessageTooLarge
+	}
+	return 1 + count + int(size), nil
+}
+
+// Returns the http handler serving the node's rpc
+// methods and metrics
+func (node *RPCNode) newHandler() (http.Handler, error) {
+	server := rpc.NewServer()
+	if err := server.Register(node); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, &rpcHandler{node.Node, server})
+	mux.HandleFunc("/metrics", node.serveMetrics)
+	return mux, nil
+}
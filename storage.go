@@ -1,28 +1,394 @@
 package chord
 
-// dataStore is an alias to map data structure
-// with string type keys and string type values
-type dataStore map[string][]byte
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+)
+
+// dataStore holds the Items of a node by Key. Every
+// change is written through to engine if there is one,
+// sealed with the current master key if keys is set.
+type dataStore struct {
+	items map[string]Item
+
+	// highest version of any Item the store has held.
+	// New versions exceed it, so that a Key which is
+	// deleted, or expires, and is written again never
+	// reuses a version a client may still hold.
+	version uint64
+
+	// bytes of the Keys and Values stored, see footprint
+	bytes int
+
+	// hashes of the Items for anti-entropy
+	leaves *merkleLeaves
+
+	// called with the Item of a Key before and after
+	// every change to it, nil if there is none
+	changed func(key string, old, item *Item)
+
+	// engine the Items are persisted to, nil to keep
+	// them in memory only, and the master keys their
+	// records are sealed with, nil to store them plain
+	engine Engine
+	keys   *masterKeys
+
+	// set while records may be sealed with a master
+	// key other than the current one, or be plain
+	stale bool
+
+	// called when a change could not be written
+	// to engine, nil if there is none
+	failed func(key string, err error)
+}
+
+func newDataStore() *dataStore {
+	return &dataStore{items: make(map[string]Item), leaves: newMerkleLeaves()}
+}
 
 type KeyValue struct {
 	Key   string
 	Value []byte
 }
 
-// Save a Key-Value pair
-func (data dataStore) set(key string, value []byte) {
-	data[key] = value
+// Item is a Value along with its version. Every write
+// to a Key gives it a version higher than any the node
+// has held, so versions of a Key only ever grow.
+type Item struct {
+	Value   []byte
+	Version uint64
+
+	// time after which the Item no longer exists,
+	// zero if it never expires. The absolute time is
+	// kept so that expiry is preserved when Items
+	// move between nodes.
+	Expires time.Time
+
+	// Deleted marks a tombstone left in place of a
+	// deleted Value, so that replicas holding an older
+	// version do not bring the Key back. Tombstones
+	// expire after Config.TombstoneTTL.
+	Deleted bool
+
+	// concurrent Values of the Key when vector clocks
+	// are enabled, see Config.VectorClocks. Value and
+	// Deleted are then derived from the siblings.
+	Siblings []Sibling
+
+	// set if the Value is stored as fragments on the
+	// owner and the nodes following it, Value is then
+	// empty in the store and rebuilt on reads
+	Erasure *Erasure
+}
+
+// Check if item a should replace item b when two
+// copies of a Key meet. Higher version wins and ties
+// are broken the same way on every node, fragments
+// which were repaired last win among equal versions. Items with
+// siblings are compared by their clocks instead, a is
+// newer if b has not seen one of its siblings.
+func newer(a, b Item) bool {
+	if len(a.Siblings) > 0 || len(b.Siblings) > 0 {
+		for _, s := range a.Siblings {
+			seen := false
+			for _, t := range b.Siblings {
+				if t.Clock.descends(s.Clock) {
+					seen = true
+					break
+				}
+			}
+			if !seen {
+				return true
+			}
+		}
+		return false
+	}
+
+	if a.Version != b.Version {
+		return a.Version > b.Version
+	}
+	if a.Deleted != b.Deleted {
+		return a.Deleted
+	}
+	if a.Erasure != nil && b.Erasure != nil && a.Erasure.Repaired != b.Erasure.Repaired {
+		return a.Erasure.Repaired > b.Erasure.Repaired
+	}
+	return bytes.Compare(a.Value, b.Value) == 1
+}
+
+// Check if item has expired at given time
+func (item Item) expired(now time.Time) bool {
+	return !item.Expires.IsZero() && !now.Before(item.Expires)
+}
+
+// WriteRequest is a write to a single Key
+type WriteRequest struct {
+	Key   string
+	Value []byte
+
+	// delete the Key instead of setting Value
+	Delete bool
+
+	// apply the write only if the current version of
+	// the Key equals Version. Version 0 means that the
+	// Key must not exist.
+	Conditional bool
+	Version     uint64
+
+	// time after which the written Value expires,
+	// zero if it never expires
+	TTL time.Duration
+
+	// number of replicas which must hold the
+	// write before it is acknowledged
+	Consistency Consistency
+
+	// clock of the siblings the writer has read, which
+	// the write replaces. Used only when vector clocks
+	// are enabled.
+	Context VectorClock
+
+	// fragments stored in place of Value, set by the
+	// node coordinating the write of a large Value
+	Erasure *Erasure
+}
+
+// WriteResult is the outcome of a successful write
+type WriteResult struct {
+	// version of the Key after the write,
+	// for deletes the version deleted
+	Version uint64
+
+	// address of the node which owns the Key
+	Node string
+
+	// number of replicas which acknowledged the
+	// write, the owner included
+	Replicas int
+
+	// the owner could not be reached, the write is
+	// held by Node until it can be replayed to the owner
+	Hinted bool
+
+	// number of fragments of the Value stored,
+	// zero if the Value is not erasure coded
+	Fragments int
+}
+
+// Returns the version of the next write to key
+func (data *dataStore) nextVersion(key string) uint64 {
+	version := data.items[key].Version
+	if data.version > version {
+		version = data.version
+	}
+	return version + 1
+}
+
+// Save a Key-Value pair expiring at given time and
+// return its new version. Zero time never expires.
+func (data *dataStore) set(key string, value []byte, expires time.Time) uint64 {
+	return data.setItem(key, Item{Value: value, Expires: expires})
+}
+
+// Save an Item under the next version of its
+// Key and return that version
+func (data *dataStore) setItem(key string, item Item) uint64 {
+	item.Version = data.nextVersion(key)
+	data.put(key, item)
+	return item.Version
 }
 
-// Return the Value associated with the given Key
-func (data dataStore) get(key string) ([]byte, bool) {
-	value, ok := data[key]
-	return value, ok
+// Replace the Value of a Key with a tombstone
+// expiring at given time and return its version
+func (data *dataStore) tombstone(key string, expires time.Time) uint64 {
+	version := data.nextVersion(key)
+	data.put(key, Item{Version: version, Expires: expires, Deleted: true})
+	return version
+}
+
+// Save an Item received from another node if it is
+// newer than the stored one, siblings of both are
+// reconciled. Returns true if saved.
+func (data *dataStore) merge(key string, item Item) bool {
+	current, ok := data.items[key]
+	if !ok {
+		data.put(key, item)
+		return true
+	}
+	if !newer(item, current) {
+		return false
+	}
+	data.put(key, reconcile(current, item))
+	return true
+}
+
+// Save an Item as is keeping its version, used
+// when Items are moved between nodes
+func (data *dataStore) put(key string, item Item) {
+	data.insert(key, item)
+	data.persist(key, &item)
+}
+
+// Save an Item in memory only, used when
+// Items are read back from the engine
+func (data *dataStore) insert(key string, item Item) {
+	if item.Version > data.version {
+		data.version = item.Version
+	}
+	old, ok := data.items[key]
+	if ok {
+		data.bytes -= len(key) + old.footprint()
+	}
+	data.items[key] = item
+	data.bytes += len(key) + item.footprint()
+	data.leaves.set(key, item)
+	if data.changed != nil {
+		if ok {
+			data.changed(key, &old, &item)
+		} else {
+			data.changed(key, nil, &item)
+		}
+	}
+}
+
+// Remove the Item of a Key, tombstones included
+func (data *dataStore) remove(key string) {
+	if old, ok := data.items[key]; ok {
+		delete(data.items, key)
+		data.bytes -= len(key) + old.footprint()
+		data.leaves.remove(key)
+		if data.changed != nil {
+			data.changed(key, &old, nil)
+		}
+		data.persist(key, nil)
+	}
+}
+
+// Returns the id the record of key is stored under in the engine
+func recordId(key string) string {
+	return hex.EncodeToString(getHash(key))
+}
+
+// Writes the Item of key to the engine,
+// or deletes its record if item is nil
+func (data *dataStore) persist(key string, item *Item) {
+	if data.engine == nil {
+		return
+	}
+
+	id := recordId(key)
+	var err error
+	if item == nil {
+		err = data.engine.Delete(id)
+	} else {
+		var record []byte
+		if record, err = data.keys.seal(id, key, *item); err == nil {
+			err = data.engine.Put(id, record)
+		}
+	}
+	if err != nil && data.failed != nil {
+		data.failed(key, err)
+	}
+}
+
+// Reads back every Item persisted by the engine.
+// Fails if a record cannot be opened, such as one
+// sealed with a master key missing from keys.
+func (data *dataStore) load() error {
+	return data.engine.Load(func(id string, record []byte) error {
+		ti, err := data.keys.open(id, record)
+		if err != nil {
+			return err
+		}
+		if data.keys != nil && !data.keys.sealedWithCurrent(record) {
+			data.stale = true
+		}
+		data.insert(ti.Key, ti.Item)
+		return nil
+	})
+}
+
+// Rewraps the record of key with the current master
+// key, returns true if the record was written again
+func (data *dataStore) rewrap(key string) (bool, error) {
+	if data.engine == nil || data.keys == nil {
+		return false, nil
+	}
+
+	id := recordId(key)
+	record, err := data.engine.Get(id)
+	if err != nil || record == nil {
+		return false, err
+	}
+	if record, err = data.keys.rewrap(id, record); err != nil || record == nil {
+		return false, err
+	}
+	return true, data.engine.Put(id, record)
+}
+
+// Return the Item associated with the given Key.
+// Expired Items and tombstones are treated as
+// missing, they are removed later by expire.
+func (data *dataStore) get(key string) (Item, bool) {
+	item, ok := data.items[key]
+	if !ok || item.Deleted || item.expired(time.Now()) {
+		return Item{}, false
+	}
+	return item, true
+}
+
+// Return the Item stored under the given Key,
+// tombstones and expired Items included
+func (data *dataStore) stored(key string) (Item, bool) {
+	item, ok := data.items[key]
+	return item, ok
+}
+
+// Calls f with every Key and Item stored,
+// tombstones and expired Items included
+func (data *dataStore) each(f func(key string, item Item)) {
+	for key, item := range data.items {
+		f(key, item)
+	}
+}
+
+// Returns the number of Items stored,
+// tombstones and expired Items included
+func (data *dataStore) len() int {
+	return len(data.items)
+}
+
+// Returns the number of Items which have neither
+// expired nor been deleted, those get returns
+func (data *dataStore) live() int {
+	now, count := time.Now(), 0
+	for _, item := range data.items {
+		if !item.Deleted && !item.expired(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// Delete the Items and tombstones which have expired
+// by given time and return the count of Items
+func (data *dataStore) expire(now time.Time) int {
+	count := 0
+	for key, item := range data.items {
+		if item.expired(now) {
+			data.remove(key)
+			if !item.Deleted {
+				count++
+			}
+		}
+	}
+	return count
 }
 
 // Delete Key-Value pairs
-func (data dataStore) del(keys []string) {
+func (data *dataStore) del(keys []string) {
 	for _, key := range keys {
-		delete(data, key)
+		data.remove(key)
 	}
 }
@@ -0,0 +1,56 @@
+package chord
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionsNeverReused(t *testing.T) {
+	data := newDataStore()
+	first := data.set("key", []byte("value"), time.Time{})
+	data.del([]string{"key"})
+	if again := data.set("key", []byte("value"), time.Time{}); again <= first {
+		t.Errorf("version %d after delete, was %d", again, first)
+	}
+
+	// nor once the Key has expired and been swept
+	expired := data.set("expiring", []byte("value"), time.Now().Add(-time.Second))
+	data.expire(time.Now())
+	if again := data.set("expiring", []byte("value"), time.Time{}); again <= expired {
+		t.Errorf("version %d after expiry, was %d", again, expired)
+	}
+}
+
+func TestLiveSkipsTombstonesAndExpiredItems(t *testing.T) {
+	data := newDataStore()
+	data.set("live", []byte("value"), time.Time{})
+	data.set("expiring", []byte("value"), time.Now().Add(time.Hour))
+	data.set("expired", []byte("value"), time.Now().Add(-time.Second))
+	data.tombstone("deleted", time.Now().Add(time.Hour))
+
+	if live := data.live(); live != 2 {
+		t.Errorf("%d live Items of %d stored, want 2", live, data.len())
+	}
+}
+
+func TestApplyWriteStoresErasureOnce(t *testing.T) {
+	node := newTestNode()
+	changes := 0
+	node.store.changed = func(key string, old, item *Item) {
+		changes++
+		node.countUsage(key, old, item)
+	}
+
+	erasure := &Erasure{Id: "write", Size: 10, Data: 2, Parity: 1}
+	version, err := node.applyWrite(&WriteRequest{Key: "coded", Erasure: erasure})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes != 1 {
+		t.Errorf("write stored %d times", changes)
+	}
+	item, _ := node.store.stored("coded")
+	if item.Version != version || item.Erasure == nil || item.Erasure.Id != "write" {
+		t.Errorf("stored %+v for version %d", item, version)
+	}
+}
@@ -0,0 +1,172 @@
+package chord
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// LoadTLSConfig returns the TLS settings of a node or client
+// presenting the certificate in certFile with its key in keyFile.
+// Peers are verified against the CA certificates in caFile, or
+// the system roots if it is empty. With mutual set the node also
+// requires the nodes and clients connecting to it to present a
+// certificate signed by the CA.
+func LoadTLSConfig(certFile, keyFile, caFile string, mutual bool) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+		config.ClientCAs = pool
+	}
+
+	if mutual {
+		if caFile == "" {
+			return nil, fmt.Errorf("mutual TLS requires a CA file")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// EphemeralCA is a certificate authority which lives only
+// in memory. It issues certificates to the nodes and clients
+// of a ring run offline, for example in tests.
+type EphemeralCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+// NewEphemeralCA creates a CA valid for a day
+func NewEphemeralCA() (*EphemeralCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chord ephemeral CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &EphemeralCA{cert, key, pool}, nil
+}
+
+// Certificate issues a certificate of a node for hosts, IP
+// addresses or host names, usable by both servers and clients.
+// The certificate carries NodeUnit, see Config.CertificateAuth.
+func (ca *EphemeralCA) Certificate(hosts ...string) (tls.Certificate, error) {
+	return ca.issue(pkix.Name{CommonName: "chord node", OrganizationalUnit: []string{NodeUnit}}, hosts)
+}
+
+// Issues a certificate of given subject for hosts
+func (ca *EphemeralCA) issue(subject pkix.Name, hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     ca.cert.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// TLSConfig returns the TLS settings of a node with a
+// certificate for hosts issued by the CA, trusting the CA
+// alone. With mutual set peers must present a certificate too.
+func (ca *EphemeralCA) TLSConfig(mutual bool, hosts ...string) (*tls.Config, error) {
+	cert, err := ca.Certificate(hosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca.pool,
+		ClientCAs:    ca.pool,
+	}
+	if mutual {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig returns the TLS settings of a client with a
+// certificate issued by the CA which is not that of a node
+func (ca *EphemeralCA) ClientTLSConfig() (*tls.Config, error) {
+	cert, err := ca.issue(pkix.Name{CommonName: "chord client"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca.pool,
+	}, nil
+}
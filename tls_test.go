@@ -0,0 +1,40 @@
+package chord
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestRingRejectsPlainConnections(t *testing.T) {
+	r := sharedRing(t)
+	if client, err := Dial(r.nodes[0].address); err == nil {
+		client.Close()
+		t.Fatal("connected to a TLS node without TLS")
+	}
+
+	// mutual TLS requires clients to present a certificate
+	if client, err := DialTLS(r.nodes[0].address, &tls.Config{RootCAs: r.ca.pool}); err == nil {
+		client.Close()
+		t.Fatal("connected to a mutual TLS node without a certificate")
+	}
+
+	client := r.client(t)
+	defer client.Close()
+	if _, err := client.Put("tls", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRingWalksNodesOverTLS(t *testing.T) {
+	r := sharedRing(t)
+	client := r.client(t)
+	defer client.Close()
+
+	nodes, err := client.Ring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != len(r.nodes) {
+		t.Errorf("walked %d of %d nodes", len(nodes), len(r.nodes))
+	}
+}
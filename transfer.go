@@ -0,0 +1,356 @@
+package chord
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Number of times a transfer is attempted before
+// the remaining keys are left on the node
+const transferAttempts = 5
+
+// Time after which state of an incoming transfer
+// which is neither complete nor progressing is dropped
+const transferIdleTimeout = 10 * time.Minute
+
+// TransferItem is a Key and its Item
+type TransferItem struct {
+	Key  string
+	Item Item
+}
+
+// TransferChunk is one part of a transfer of keys
+// between two nodes
+type TransferChunk struct {
+	// identifies the transfer, chunks of a transfer
+	// which is resumed keep the same id
+	TransferId string
+
+	// position of the chunk in the transfer
+	// and number of chunks in the transfer
+	Seq   int
+	Total int
+
+	Items []TransferItem
+
+	// CRC-32 of the items, see checksum
+	Checksum uint32
+}
+
+// incomingTransfer tracks the chunks received
+// for a transfer to this node
+type incomingTransfer struct {
+	received map[int]bool
+	total    int
+	updated  time.Time
+}
+
+// Returns the sequence number of the first
+// chunk which has not been received
+func (t *incomingTransfer) next() int {
+	next := 0
+	for t.received[next] {
+		next++
+	}
+	return next
+}
+
+// Computes the CRC-32 of items over
+// a fixed encoding of their fields
+func checksum(items []TransferItem) uint32 {
+	h := crc32.NewIEEE()
+	for _, ti := range items {
+		writeItem(h, ti)
+	}
+	return h.Sum32()
+}
+
+// Writes a fixed encoding of the fields of an
+// Item and its Key, used for hashing
+func writeItem(w io.Writer, ti TransferItem) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(len(ti.Key)))
+	w.Write(buf)
+	w.Write([]byte(ti.Key))
+	binary.BigEndian.PutUint64(buf, uint64(len(ti.Item.Value)))
+	w.Write(buf)
+	w.Write(ti.Item.Value)
+	binary.BigEndian.PutUint64(buf, ti.Item.Version)
+	w.Write(buf)
+	var expires int64
+	if !ti.Item.Expires.IsZero() {
+		expires = ti.Item.Expires.UnixNano()
+	}
+	binary.BigEndian.PutUint64(buf, uint64(expires))
+	w.Write(buf)
+	writeBool(w, ti.Item.Deleted)
+
+	binary.BigEndian.PutUint64(buf, uint64(len(ti.Item.Siblings)))
+	w.Write(buf)
+	for _, sibling := range ti.Item.Siblings {
+		binary.BigEndian.PutUint64(buf, uint64(len(sibling.Value)))
+		w.Write(buf)
+		w.Write(sibling.Value)
+		clock := sibling.Clock.String()
+		binary.BigEndian.PutUint64(buf, uint64(len(clock)))
+		w.Write(buf)
+		w.Write([]byte(clock))
+		writeBool(w, sibling.Deleted)
+	}
+
+	writeBool(w, ti.Item.Erasure != nil)
+	if erasure := ti.Item.Erasure; erasure != nil {
+		binary.BigEndian.PutUint64(buf, uint64(len(erasure.Id)))
+		w.Write(buf)
+		w.Write([]byte(erasure.Id))
+		for _, n := range []int{erasure.Size, erasure.Data, erasure.Parity, erasure.Repaired} {
+			binary.BigEndian.PutUint64(buf, uint64(n))
+			w.Write(buf)
+		}
+		for i, holder := range erasure.Holders {
+			binary.BigEndian.PutUint64(buf, uint64(len(holder)))
+			w.Write(buf)
+			w.Write([]byte(holder))
+			w.Write(erasure.Hashes[i])
+		}
+	}
+}
+
+func writeBool(w io.Writer, b bool) {
+	if b {
+		w.Write([]byte{1})
+	} else {
+		w.Write([]byte{0})
+	}
+}
+
+// Splits keys into chunks of at most Config.TransferChunkSize
+// bytes each, counting the Items as they are encoded
+func (node *Node) chunkKeys(keys []string) [][]string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	chunks := make([][]string, 0)
+	var chunk []string
+	size := 0
+	for _, key := range keys {
+		item, _ := node.store.stored(key)
+		itemSize := TransferItem{key, item}.size()
+		if len(chunk) > 0 && size+itemSize > node.config.TransferChunkSize {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, key)
+		size += itemSize
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Reads the current Items of keys into a chunk.
+// Keys which no longer exist are left out.
+func (node *Node) buildChunk(id string, seq int, total int, keys []string) *TransferChunk {
+	chunk := &TransferChunk{TransferId: id, Seq: seq, Total: total}
+
+	// tombstones are transferred too
+	node.mutex.RLock()
+	for _, key := range keys {
+		if item, ok := node.store.stored(key); ok {
+			chunk.Items = append(chunk.Items, TransferItem{key, item})
+		}
+	}
+	node.mutex.RUnlock()
+
+	chunk.Checksum = checksum(chunk.Items)
+	return chunk
+}
+
+// Deletes the keys of a chunk which has been received,
+// keys written to after the chunk was built are kept
+func (node *Node) completeChunk(chunk *TransferChunk) {
+	node.mutex.Lock()
+	for _, ti := range chunk.Items {
+		if current, ok := node.store.stored(ti.Key); ok && current.Version == ti.Item.Version {
+			node.store.del([]string{ti.Key})
+		}
+	}
+	if transfer, ok := node.transfers[chunk.TransferId]; ok {
+		transfer.Sent += len(chunk.Items)
+		node.transfers[chunk.TransferId] = transfer
+	}
+	node.mutex.Unlock()
+
+	node.metrics.keysTransferredOut.add(len(chunk.Items))
+}
+
+// Streams keys to the node at address "to" in chunks. A
+// failed transfer is resumed from the first chunk which
+// the receiver is missing.
+func (node *Node) streamKeys(to string, keys []string) error {
+	chunks := node.chunkKeys(keys)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	id := fmt.Sprintf("%s-%d", node.address, time.Now().UnixNano())
+	defer node.trackTransfer(id, to, len(keys))()
+
+	// chunks sent but not yet acknowledged by sequence number
+	unacked := make(map[int]*TransferChunk)
+
+	var err error
+	for attempt := 0; attempt < transferAttempts; attempt++ {
+		if attempt > 0 {
+			node.logger.Warn("resuming transfer", "to", to, "attempt", attempt, "err", err)
+			time.Sleep(time.Second)
+		}
+
+		var toRPC *rpc.Client
+		toRPC, err = node.getClient(to)
+		if err != nil {
+			continue
+		}
+
+		// ask receiver where to resume from, chunks before
+		// it were received even if their ack was lost
+		from := 0
+		if attempt > 0 {
+			if err = toRPC.Call("RPCNode.TransferStatus", &id, &from); err != nil {
+				toRPC.Close()
+				continue
+			}
+			for seq, chunk := range unacked {
+				if seq < from {
+					node.completeChunk(chunk)
+					delete(unacked, seq)
+				}
+			}
+		}
+
+		err = node.sendChunks(toRPC, id, chunks, from, unacked)
+		toRPC.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Sends chunks starting from chunk number "from". At most
+// Config.TransferWindow chunks are in flight at a time.
+// Chunks are kept in unacked until the receiver acks them.
+func (node *Node) sendChunks(toRPC *rpc.Client, id string, chunks [][]string, from int, unacked map[int]*TransferChunk) error {
+	window := make(chan struct{}, node.config.TransferWindow)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var failure error
+
+	for seq := from; seq < len(chunks); seq++ {
+		window <- struct{}{}
+
+		mutex.Lock()
+		failed := failure != nil
+		mutex.Unlock()
+		if failed {
+			<-window
+			break
+		}
+
+		chunk := node.buildChunk(id, seq, len(chunks), chunks[seq])
+		mutex.Lock()
+		unacked[seq] = chunk
+		mutex.Unlock()
+
+		var next int
+		call := toRPC.Go("RPCNode.ReceiveChunk", chunk, &next, nil)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-call.Done
+			<-window
+
+			if call.Error != nil {
+				mutex.Lock()
+				failure = call.Error
+				mutex.Unlock()
+				return
+			}
+			node.completeChunk(chunk)
+			mutex.Lock()
+			delete(unacked, chunk.Seq)
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	return failure
+}
+
+// Saves a chunk of a transfer into node's store and
+// replies with the first chunk not yet received.
+// Items not newer than the ones stored are ignored.
+// The transfer is forgotten once every chunk is in.
+func (node *RPCNode) ReceiveChunk(chunk *TransferChunk, next *int) error {
+	if checksum(chunk.Items) != chunk.Checksum {
+		return ErrChecksumMismatch
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	transfer, ok := node.incoming[chunk.TransferId]
+	if !ok {
+		transfer = &incomingTransfer{received: make(map[int]bool), total: chunk.Total}
+		node.incoming[chunk.TransferId] = transfer
+	}
+	transfer.updated = time.Now()
+
+	if !transfer.received[chunk.Seq] {
+		for _, ti := range chunk.Items {
+			if node.store.merge(ti.Key, ti.Item) {
+				node.logger.Debug("received key", "key", ti.Key, "value", node.redact(ti.Item.Value))
+			}
+		}
+		transfer.received[chunk.Seq] = true
+		node.metrics.keysTransferredIn.add(len(chunk.Items))
+	}
+
+	*next = transfer.next()
+	if *next >= transfer.total {
+		delete(node.incoming, chunk.TransferId)
+	}
+	return nil
+}
+
+// Replies with the first chunk of a transfer
+// which has not been received
+func (node *RPCNode) TransferStatus(id *string, next *int) error {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	if transfer, ok := node.incoming[*id]; ok {
+		*next = transfer.next()
+	}
+	return nil
+}
+
+// Drops the state of incoming transfers
+// which have been idle for long
+func (node *Node) expireTransfers() {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	for id, transfer := range node.incoming {
+		if time.Since(transfer.updated) > transferIdleTimeout {
+			delete(node.incoming, id)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package chord
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Returns chunk seq of total holding a single Item
+func testChunk(id string, seq, total int) *TransferChunk {
+	key := fmt.Sprint("chunk-", seq)
+	items := []TransferItem{{key, Item{Value: []byte(key), Version: 1}}}
+	return &TransferChunk{TransferId: id, Seq: seq, Total: total, Items: items, Checksum: checksum(items)}
+}
+
+func TestChecksumCoversItems(t *testing.T) {
+	items := []TransferItem{{"key", Item{Value: []byte("value"), Version: 1}}}
+	sum := checksum(items)
+
+	changed := []TransferItem{{"key", Item{Value: []byte("value"), Version: 2}}}
+	if checksum(changed) == sum {
+		t.Error("checksum does not cover the version")
+	}
+	changed = []TransferItem{{"key", Item{Value: []byte("value"), Version: 1, Expires: time.Now()}}}
+	if checksum(changed) == sum {
+		t.Error("checksum does not cover the expiry")
+	}
+	changed = []TransferItem{{"key", Item{Value: []byte("value"), Version: 1, Deleted: true}}}
+	if checksum(changed) == sum {
+		t.Error("checksum does not cover tombstones")
+	}
+	changed = []TransferItem{{"kez", Item{Value: []byte("value"), Version: 1}}}
+	if checksum(changed) == sum {
+		t.Error("checksum does not cover the key")
+	}
+}
+
+func TestReceiveChunkRejectsCorruptChunk(t *testing.T) {
+	node := newTestNode()
+	chunk := testChunk("corrupt", 0, 1)
+	chunk.Items[0].Item.Value = []byte("flipped")
+
+	var next int
+	if err := node.ReceiveChunk(chunk, &next); err != ErrChecksumMismatch {
+		t.Fatalf("corrupt chunk received: %v", err)
+	}
+	if _, ok := node.store.stored(chunk.Items[0].Key); ok {
+		t.Error("corrupt chunk stored")
+	}
+}
+
+func TestReceiveChunkResumes(t *testing.T) {
+	node := newTestNode()
+	id := "resumed"
+
+	var next int
+	for _, seq := range []int{0, 2} {
+		if err := node.ReceiveChunk(testChunk(id, seq, 4), &next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next != 1 {
+		t.Fatalf("next = %d after chunks 0 and 2", next)
+	}
+
+	// a sender which lost the replies asks where to resume
+	status := -1
+	if err := node.TransferStatus(&id, &status); err != nil || status != 1 {
+		t.Fatalf("status = %d, %v", status, err)
+	}
+
+	// chunks sent again are not applied twice
+	if err := node.ReceiveChunk(testChunk(id, 0, 4), &next); err != nil || next != 1 {
+		t.Fatalf("next = %d, %v after chunk 0 again", next, err)
+	}
+
+	for _, seq := range []int{1, 3} {
+		if err := node.ReceiveChunk(testChunk(id, seq, 4), &next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next != 4 {
+		t.Fatalf("next = %d once every chunk is in", next)
+	}
+	if _, ok := node.incoming[id]; ok {
+		t.Error("state of complete transfer kept")
+	}
+	if node.store.len() != 4 {
+		t.Errorf("stored %d keys of 4", node.store.len())
+	}
+}
+
+func TestCompleteChunkKeepsNewerWrites(t *testing.T) {
+	node := newTestNode()
+	node.store.set("sent", []byte("old"), time.Time{})
+	node.store.set("rewritten", []byte("old"), time.Time{})
+	chunk := node.buildChunk("complete", 0, 1, []string{"sent", "rewritten", "missing"})
+	if len(chunk.Items) != 2 {
+		t.Fatalf("chunk holds %d items", len(chunk.Items))
+	}
+
+	node.store.set("rewritten", []byte("new"), time.Time{})
+	node.completeChunk(chunk)
+
+	if _, ok := node.store.stored("sent"); ok {
+		t.Error("sent key kept")
+	}
+	if item, ok := node.store.get("rewritten"); !ok || string(item.Value) != "new" {
+		t.Error("key written after the chunk was built deleted")
+	}
+}
+
+func TestChunkKeysCountsSiblings(t *testing.T) {
+	node := newTestNode()
+	node.config.TransferChunkSize = 1000
+	keys := []string{"a", "b", "c"}
+	for _, key := range keys {
+		node.store.put(key, Item{Version: 1, Siblings: []Sibling{
+			{Value: make([]byte, 400), Clock: VectorClock{"127.0.0.1:1": 1}},
+			{Value: make([]byte, 400), Clock: VectorClock{"127.0.0.1:2": 1}},
+		}})
+	}
+
+	if chunks := node.chunkKeys(keys); len(chunks) != len(keys) {
+		t.Errorf("%d keys of 800 bytes of siblings in %d chunks of 1000 bytes", len(keys), len(chunks))
+	}
+}
@@ -3,7 +3,9 @@ package chord
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/tls"
 	"math/big"
+	"net"
 	"net/rpc"
 )
 
@@ -44,9 +46,32 @@ func getHash(str string) []byte {
 	return h.Sum(nil)
 }
 
-func getClient(address string) (*rpc.Client, error) {
-	client, err := rpc.DialHTTP("tcp", address)
+// Returns an rpc client of the node at address
+// connected with the node's credentials
+func (node *Node) getClient(address string) (*rpc.Client, error) {
+	return getClient(address, node.credentials())
+}
+
+// Returns an rpc client of the node at address, over TLS
+// if set in creds. See Config.TLS and Config.ClusterKey.
+func getClient(address string, creds Credentials) (*rpc.Client, error) {
+	var conn net.Conn
+	var err error
+	if creds.TLS != nil {
+		conn, err = tls.Dial("tcp", address, creds.TLS)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return &rpc.Client{}, ErrUnableToDial
+	}
+
+	client, err := connectRPC(conn, address, creds.ClusterKey)
 	if err != nil {
+		conn.Close()
+		if err == ErrUnauthenticated {
+			return &rpc.Client{}, err
+		}
 		return &rpc.Client{}, ErrUnableToDial
 	}
 	return client, nil
@@ -0,0 +1,242 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VectorClock counts the writes to a Key
+// coordinated by each node, keyed by address
+type VectorClock map[string]uint64
+
+// Sibling is one of the concurrent
+// Values of a Key and its clock
+type Sibling struct {
+	Value   []byte
+	Clock   VectorClock
+	Deleted bool
+}
+
+// Check if vc has seen every write
+// which other has seen
+func (vc VectorClock) descends(other VectorClock) bool {
+	for address, count := range other {
+		if vc[address] < count {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a clock which has seen
+// the writes of both vc and other
+func (vc VectorClock) merge(other VectorClock) VectorClock {
+	merged := make(VectorClock, len(vc))
+	for address, count := range vc {
+		merged[address] = count
+	}
+	for address, count := range other {
+		if merged[address] < count {
+			merged[address] = count
+		}
+	}
+	return merged
+}
+
+// Formats vc as address=count pairs
+// separated by commas, ordered by address
+func (vc VectorClock) String() string {
+	addresses := make([]string, 0, len(vc))
+	for address := range vc {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	pairs := make([]string, len(addresses))
+	for i, address := range addresses {
+		pairs[i] = address + "=" + strconv.FormatUint(vc[address], 10)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseVectorClock parses a clock
+// formatted by VectorClock.String
+func ParseVectorClock(s string) (VectorClock, error) {
+	vc := make(VectorClock)
+	if s == "" {
+		return vc, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid vector clock entry %q", pair)
+		}
+		count, err := strconv.ParseUint(pair[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector clock entry %q", pair)
+		}
+		vc[pair[:i]] = count
+	}
+	return vc, nil
+}
+
+// Context returns the clock which has seen every sibling
+// of item. A write carrying it replaces the siblings.
+func (item Item) Context() VectorClock {
+	context := make(VectorClock)
+	for _, sibling := range item.Siblings {
+		context = context.merge(sibling.Clock)
+	}
+	return context
+}
+
+// Sets Value and Deleted of item from its siblings. Value
+// is the first sibling which is not a tombstone, the Item
+// is deleted only if every sibling is a tombstone.
+func (item *Item) fromSiblings() {
+	sort.Slice(item.Siblings, func(i, j int) bool {
+		a, b := item.Siblings[i], item.Siblings[j]
+		if c := strings.Compare(a.Clock.String(), b.Clock.String()); c != 0 {
+			return c == -1
+		}
+		return bytes.Compare(a.Value, b.Value) == -1
+	})
+
+	item.Value, item.Deleted = nil, true
+	for _, sibling := range item.Siblings {
+		if !sibling.Deleted {
+			item.Value, item.Deleted = sibling.Value, false
+			break
+		}
+	}
+}
+
+// Save a Value, or a tombstone if deleted, as a sibling written
+// by node at address "by" which has seen the writes in context.
+// Siblings seen by the new write are replaced, the others are
+// kept as concurrent siblings. Returns the new version of the Key.
+func (data *dataStore) setSibling(key string, value []byte, deleted bool, context VectorClock, by string, expires time.Time) uint64 {
+	current := data.items[key]
+
+	// count of the new write must exceed every
+	// write of this node the Key has seen
+	clock := context.merge(nil)
+	for _, sibling := range current.Siblings {
+		if sibling.Clock[by] > clock[by] {
+			clock[by] = sibling.Clock[by]
+		}
+	}
+	clock[by]++
+
+	siblings := []Sibling{{value, clock, deleted}}
+	for _, sibling := range current.Siblings {
+		if !clock.descends(sibling.Clock) {
+			siblings = append(siblings, sibling)
+		}
+	}
+
+	item := Item{Version: data.nextVersion(key), Expires: expires, Siblings: siblings}
+	item.fromSiblings()
+
+	// a tombstone concurrent with live siblings
+	// must not make them expire
+	if deleted && !item.Deleted {
+		item.Expires = current.Expires
+	}
+	data.put(key, item)
+	return item.Version
+}
+
+// Returns the Item holding the writes of both a and b. Items
+// without siblings are resolved by version, for the others
+// siblings seen by another sibling are dropped.
+func reconcile(a, b Item) Item {
+	if len(a.Siblings) == 0 && len(b.Siblings) == 0 {
+		if newer(b, a) {
+			return b
+		}
+		return a
+	}
+
+	all := append(append([]Sibling{}, a.Siblings...), b.Siblings...)
+	kept := make([]Sibling, 0, len(all))
+	for i, s := range all {
+		seen := false
+		for j, t := range all {
+			// of equal clocks the first is kept
+			if i != j && t.Clock.descends(s.Clock) && (j < i || !s.Clock.descends(t.Clock)) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			kept = append(kept, s)
+		}
+	}
+
+	item := Item{Version: a.Version, Expires: a.Expires, Siblings: kept}
+	if b.Version > item.Version {
+		item.Version = b.Version
+	}
+	if !item.Expires.IsZero() && (b.Expires.IsZero() || b.Expires.After(item.Expires)) {
+		item.Expires = b.Expires
+	}
+	item.fromSiblings()
+	return item
+}
+
+// GetSiblings returns the concurrent Values of the Key and the
+// context to pass to PutWithContext to replace them. Keys written
+// without vector clocks have a single sibling and no context.
+func (c *Client) GetSiblings(key string, consistency Consistency) ([]Sibling, VectorClock, error) {
+	result, err := c.GetWithConsistency(key, consistency)
+	if err != nil {
+		return nil, nil, err
+	}
+	item := result.Item
+	if len(item.Siblings) == 0 {
+		return []Sibling{{Value: item.Value}}, nil, nil
+	}
+	return item.Siblings, item.Context(), nil
+}
+
+// PutWithContext saves the Key-Value pair replacing
+// the siblings which were read along with context
+func (c *Client) PutWithContext(key string, value []byte, context VectorClock) (*WriteResult, error) {
+	return c.Write(&WriteRequest{Key: key, Value: value, Context: context})
+}
+
+// DeleteWithContext deletes the Key replacing the
+// siblings which were read along with context
+func (c *Client) DeleteWithContext(key string, context VectorClock) error {
+	_, err := c.Write(&WriteRequest{Key: key, Delete: true, Context: context})
+	return err
+}
+
+// Resolve reads the Key and, if it has concurrent siblings,
+// saves the Value returned by merge in their place. Returns
+// the Value of the Key after resolving.
+func (c *Client) Resolve(key string, consistency Consistency, merge func(values [][]byte) []byte) ([]byte, error) {
+	siblings, context, err := c.GetSiblings(key, consistency)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, 0, len(siblings))
+	for _, sibling := range siblings {
+		if !sibling.Deleted {
+			values = append(values, sibling.Value)
+		}
+	}
+	if len(siblings) == 1 {
+		return values[0], nil
+	}
+
+	value := merge(values)
+	_, err = c.Write(&WriteRequest{Key: key, Value: value, Context: context, Consistency: consistency})
+	return value, err
+}
@@ -0,0 +1,71 @@
+package chord
+
+import (
+	"testing"
+)
+
+func TestReconcileKeepsConcurrentSiblings(t *testing.T) {
+	a := Item{Version: 1, Siblings: []Sibling{{Value: []byte("a"), Clock: VectorClock{"n1": 1}}}}
+	b := Item{Version: 2, Siblings: []Sibling{{Value: []byte("b"), Clock: VectorClock{"n2": 1}}}}
+
+	merged := reconcile(a, b)
+	if len(merged.Siblings) != 2 {
+		t.Fatalf("kept %d siblings of concurrent writes", len(merged.Siblings))
+	}
+	if merged.Version != 2 {
+		t.Errorf("version = %d", merged.Version)
+	}
+	if string(merged.Value) != "a" {
+		t.Errorf("value = %q, not that of the first sibling", merged.Value)
+	}
+
+	// a write which has seen both replaces them
+	c := Item{Version: 3, Siblings: []Sibling{{Value: []byte("c"), Clock: VectorClock{"n1": 1, "n2": 1}}}}
+	resolved := reconcile(merged, c)
+	if len(resolved.Siblings) != 1 || string(resolved.Value) != "c" {
+		t.Fatalf("resolved to %+v", resolved.Siblings)
+	}
+
+	// the order of reconciling does not matter
+	if other := reconcile(c, merged); len(other.Siblings) != 1 || string(other.Value) != "c" {
+		t.Errorf("reversed reconcile kept %+v", other.Siblings)
+	}
+}
+
+func TestReconcileDropsEqualClocks(t *testing.T) {
+	clock := VectorClock{"n1": 2}
+	a := Item{Version: 1, Siblings: []Sibling{{Value: []byte("v"), Clock: clock}}}
+	if merged := reconcile(a, a); len(merged.Siblings) != 1 {
+		t.Fatalf("kept %d copies of one sibling", len(merged.Siblings))
+	}
+}
+
+func TestReconcileWithoutSiblings(t *testing.T) {
+	older := Item{Value: []byte("old"), Version: 1}
+	newer := Item{Value: []byte("new"), Version: 2}
+	if string(reconcile(older, newer).Value) != "new" || string(reconcile(newer, older).Value) != "new" {
+		t.Error("newer version lost")
+	}
+
+	tombstone := Item{Version: 2, Deleted: true}
+	if !reconcile(newer, tombstone).Deleted {
+		t.Error("tombstone of equal version lost")
+	}
+}
+
+func TestVectorClockDescends(t *testing.T) {
+	a := VectorClock{"n1": 2, "n2": 1}
+	b := VectorClock{"n1": 1}
+	if !a.descends(b) || b.descends(a) {
+		t.Error("descends is wrong for ordered clocks")
+	}
+	c := VectorClock{"n3": 1}
+	if a.descends(c) || c.descends(a) {
+		t.Error("concurrent clocks descend")
+	}
+
+	parsed, err := ParseVectorClock(a.String())
+	if err != nil || !parsed.descends(a) || !a.descends(parsed) {
+		t.Errorf("parsed %s as %v, %v", a, parsed, err)
+	}
+}